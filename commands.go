@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"raid6/raid6"
+)
+
+// A shard directory holds one file per member, named by member index,
+// plus a manifest with the name and exact size of the encoded file.
+const manifestName = "manifest.json"
+
+type manifest struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func shardPath(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%02d", i))
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments, and returns the positional ones.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	return exitError
+}
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// assembleDir assembles the array from every shard file in dir. A
+// read-only array is only inspected: no shard file is written.
+func assembleDir(dir string, readOnly bool) (*raid6.Volume, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "shard-*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no shard files in %s", dir)
+	}
+	if readOnly {
+		return raid6.AssembleReadOnly(paths...)
+	}
+	return raid6.Assemble(paths...)
+}
+
+func encodeCommand(args []string) int {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	dataShards := fs.Int("k", 5, "number of data shards")
+	parityShards := fs.Int("m", 2, "number of parity shards")
+	chunkSize := fs.Int("c", 4096, "chunk size in bytes")
+	out := fs.String("o", "", "output directory for shard files")
+	layoutName := fs.String("layout", "parity-disks", "stripe layout")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 || *out == "" {
+		return fail(errors.New("usage: encode FILE -k 5 -m 2 -o DIR"))
+	}
+	layout, err := raid6.ParseLayout(*layoutName)
+	if err != nil {
+		return fail(err)
+	}
+
+	in, err := os.Open(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	defer in.Close()
+	err = os.MkdirAll(*out, 0755)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Start from empty shard files, so no stale data is left at the end.
+	paths := make([]string, *dataShards+*parityShards)
+	for i := range paths {
+		paths[i] = shardPath(*out, i)
+		f, err := os.Create(paths[i])
+		if err != nil {
+			return fail(err)
+		}
+		f.Close()
+	}
+	v, err := raid6.CreateVolume(*dataShards, *parityShards, *chunkSize, paths...)
+	if err != nil {
+		return fail(err)
+	}
+	err = v.SetLayout(layout)
+	if err != nil {
+		v.Close()
+		return fail(err)
+	}
+
+	buf := make([]byte, v.StripeSize())
+	var size int64
+	for stripe := int64(0); ; stripe++ {
+		n, err := io.ReadFull(in, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			v.Close()
+			return fail(err)
+		}
+		size += int64(n)
+		err = v.WriteStripe(stripe, buf[:n])
+		if err != nil {
+			v.Close()
+			return fail(err)
+		}
+	}
+	err = v.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	data, _ := json.Marshal(manifest{Name: filepath.Base(positional[0]), Size: size})
+	err = os.WriteFile(filepath.Join(*out, manifestName), data, 0644)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Encoded %d bytes into %d+%d shards in %s \n", size, *dataShards, *parityShards, *out)
+	return exitOK
+}
+
+func decodeCommand(args []string) int {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default: the original name)")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fail(errors.New("usage: decode DIR -o FILE"))
+	}
+	dir := positional[0]
+	m, err := readManifest(dir)
+	if err != nil {
+		return fail(err)
+	}
+	if *out == "" {
+		*out = m.Name
+	}
+
+	v, err := assembleDir(dir, true)
+	if err != nil {
+		return fail(err)
+	}
+	defer v.Close()
+	f, err := os.Create(*out)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+
+	// Every stripe is scrubbed before it is copied: reads trust the data
+	// chunks, so a flipped bit would otherwise end up in the output.
+	buf := make([]byte, v.StripeSize())
+	inconsistent := 0
+	for off := int64(0); off < m.Size; off += int64(len(buf)) {
+		stripe := off / int64(len(buf))
+		members, err := v.ScrubStripe(stripe, false)
+		if err != nil {
+			fmt.Printf("Stripe %d: %s \n", stripe, err)
+			inconsistent++
+		}
+		for _, i := range members {
+			fmt.Printf("Stripe %d: shard %d inconsistent \n", stripe, i)
+			inconsistent++
+		}
+
+		n := int64(len(buf))
+		if m.Size-off < n {
+			n = m.Size - off
+		}
+		_, err = v.ReadAt(buf[:n], off)
+		if err != nil {
+			return fail(err)
+		}
+		_, err = f.Write(buf[:n])
+		if err != nil {
+			return fail(err)
+		}
+	}
+	fmt.Printf("Decoded %d bytes to %s \n", m.Size, *out)
+	code := exitOK
+	if v.Degraded() {
+		fmt.Printf("Warning: array is degraded, missing members %v \n", v.Missing())
+		code = exitProblems
+	}
+	if inconsistent > 0 {
+		fmt.Printf("Warning: %s may be corrupt, run repair on %s and decode again \n", *out, dir)
+		code = exitProblems
+	}
+	return code
+}
+
+// scrub checks every stripe and returns the number of inconsistent
+// stripes per member and the number of stripes that could not be checked.
+func scrub(v *raid6.Volume, repair bool) (map[int]int, int, error) {
+	stripes, err := v.StripeCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	bad := make(map[int]int)
+	unchecked := 0
+	for stripe := int64(0); stripe < stripes; stripe++ {
+		members, err := v.ScrubStripe(stripe, repair)
+		if err != nil {
+			fmt.Printf("Stripe %d: %s \n", stripe, err)
+			unchecked++
+			continue
+		}
+		for _, i := range members {
+			bad[i]++
+		}
+	}
+	return bad, unchecked, nil
+}
+
+func printBad(bad map[int]int, verb string) {
+	var members []int
+	for i := range bad {
+		members = append(members, i)
+	}
+	sort.Ints(members)
+	for _, i := range members {
+		fmt.Printf("Shard %d: %d inconsistent stripes %s \n", i, bad[i], verb)
+	}
+}
+
+func verifyCommand(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fail(errors.New("usage: verify DIR"))
+	}
+	v, err := assembleDir(positional[0], true)
+	if err != nil {
+		return fail(err)
+	}
+	defer v.Close()
+
+	code := exitOK
+	for _, i := range v.Missing() {
+		fmt.Printf("Shard %d: missing \n", i)
+		code = exitProblems
+	}
+	bad, unchecked, err := scrub(v, false)
+	if err != nil {
+		return fail(err)
+	}
+	printBad(bad, "found")
+	if len(bad) > 0 || unchecked > 0 {
+		code = exitProblems
+	}
+	if code == exitOK {
+		fmt.Println("All shards consistent")
+	}
+	return code
+}
+
+func repairCommand(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fail(errors.New("usage: repair DIR"))
+	}
+	dir := positional[0]
+	v, err := assembleDir(dir, false)
+	if err != nil {
+		return fail(err)
+	}
+	defer v.Close()
+
+	// Fix silent corruption first, so it is not copied into rebuilt shards.
+	bad, unchecked, err := scrub(v, true)
+	if err != nil {
+		return fail(err)
+	}
+	printBad(bad, "repaired")
+	if unchecked > 0 {
+		fmt.Println("Not regenerating missing shards from inconsistent stripes")
+		return exitProblems
+	}
+
+	for _, i := range v.Missing() {
+		d, err := os.Create(shardPath(dir, i))
+		if err != nil {
+			return fail(err)
+		}
+		err = v.Rebuild(i, d)
+		if err != nil {
+			d.Close()
+			return fail(err)
+		}
+		fmt.Printf("Shard %d: regenerated \n", i)
+	}
+	return exitOK
+}
+
+func infoCommand(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fail(errors.New("usage: info DIR"))
+	}
+	dir := positional[0]
+	v, err := assembleDir(dir, true)
+	if err != nil {
+		return fail(err)
+	}
+	defer v.Close()
+	info, err := v.Info()
+	if err != nil {
+		return fail(err)
+	}
+
+	if m, err := readManifest(dir); err == nil {
+		fmt.Printf("File:        %s (%d bytes)\n", m.Name, m.Size)
+	}
+	fmt.Printf("Array UUID:  %s\n", info.UUID)
+	fmt.Printf("Geometry:    %d data + %d parity, %d byte chunks, %s\n", info.DataShards, info.ParityShards, info.ChunkSize, info.Layout)
+	fmt.Printf("Stripes:     %d (%d bytes)\n", info.Stripes, info.Size)
+	fmt.Printf("Events:      %d\n", info.Events)
+	if info.Reshaping {
+		fmt.Printf("Reshape:     %d of %d stripes\n", info.ReshapeDone, info.ReshapeTotal)
+	}
+	if len(info.Missing) > 0 {
+		fmt.Printf("Health:      degraded, missing shards %v (tolerates %d more)\n", info.Missing, info.ParityShards-len(info.Missing))
+		return exitProblems
+	}
+	fmt.Printf("Health:      clean (tolerates %d failures)\n", info.ParityShards)
+	return exitOK
+}
+
+func simCommand(args []string) int {
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		return fail(errors.New("usage: sim [SCRIPT]"))
+	}
+	if len(positional) == 0 {
+		err := raid6.RunSimulator(os.Stdin, true)
+		if err != nil {
+			return fail(err)
+		}
+		return exitOK
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	err = raid6.RunSimulator(f, false)
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func planCommand(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	disks := fs.Int("disks", 12, "number of disks in the array")
+	tb := fs.Float64("tb", 16, "disk capacity in TB")
+	afr := fs.Float64("afr", 0.02, "annual failure rate of a disk")
+	mbps := fs.Float64("mbps", 100, "rebuild throughput in MB/s")
+	ure := fs.Float64("ure", 1e-15, "latent sector errors per bit read")
+	nines := fs.Float64("nines", 11, "required annual durability in nines")
+	parseArgs(fs, args)
+
+	plans, best, err := raid6.RecommendGeometry(raid6.PlanConfig{
+		Disks:              *disks,
+		DiskBytes:          *tb * 1e12,
+		AFR:                *afr,
+		RebuildBytesPerSec: *mbps * 1e6,
+		URE:                *ure,
+		TargetNines:        *nines,
+	})
+	if plans == nil {
+		return fail(err)
+	}
+
+	fmt.Printf("%-7s %12s %7s %9s %11s %8s %11s\n", "k+m", "MTTDL (y)", "nines", "overhead", "usable TB", "rebuild", "write I/Os")
+	for _, p := range plans {
+		fmt.Printf("%-7s %12.3g %7.2f %9.2f %11.1f %7.1fx %11d\n",
+			fmt.Sprintf("%d+%d", p.DataShards, p.ParityShards), p.MTTDL, p.Nines,
+			p.Overhead, p.UsableBytes/1e12, p.RebuildReads, p.SmallWriteIOs)
+	}
+	fmt.Printf("Rebuild of one disk: %.1f days \n", best.RebuildDays)
+	if err != nil {
+		fmt.Printf("No geometry reaches %.1f nines, most durable is %d+%d \n", *nines, best.DataShards, best.ParityShards)
+		return exitProblems
+	}
+	fmt.Printf("Recommended: %d data + %d parity shards (%.2f nines) \n", best.DataShards, best.ParityShards, best.Nines)
+	return exitOK
+}
+
+// scheduleCommand prints the XOR schedule costs of the Vandermonde and
+// Cauchy encoding matrices of a geometry.
+func scheduleCommand(args []string) int {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	k := fs.Int("k", 10, "number of data shards")
+	m := fs.Int("m", 4, "number of parity shards")
+	parseArgs(fs, args)
+
+	vandermonde, err := raid6.VandermondeMatrix(*k, *m)
+	if err != nil {
+		return fail(err)
+	}
+	cauchy, err := raid6.CauchyMatrix(*k, *m)
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("Vandermonde: %s\n", raid6.ReportSchedule(vandermonde[*k:]))
+	fmt.Printf("Cauchy: %s", raid6.ReportSchedule(cauchy[*k:]))
+	return exitOK
+}
@@ -2,11 +2,71 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"raid6/raid6"
 )
 
+// Exit codes, so the tool can be used from scripts.
+const (
+	exitOK       = 0 // success, array healthy
+	exitProblems = 1 // missing or inconsistent shards were found
+	exitError    = 2 // usage error or unrecoverable failure
+)
+
+const usage = `Usage: raid6 <command> [arguments]
+
+Commands:
+  encode FILE -k 5 -m 2 -o DIR   split FILE into data and parity shard files
+  decode DIR -o FILE             rebuild the original file, tolerating missing shards
+  verify DIR                     report missing and inconsistent shards
+  repair DIR                     regenerate missing and corrupt shards
+  info DIR                       print geometry and health
+  plan -disks 12 -nines 11       compare geometries and recommend data and parity shards
+  schedule -k 10 -m 4            report XOR schedule costs of the encoding matrices
+  sim [SCRIPT]                   run the fault simulator, interactively or from SCRIPT
+  demo                           run the erasure and bit flip demonstration
+
+Exit codes: 0 healthy, 1 problems found, 2 error.
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(exitError)
+	}
+
+	args := os.Args[2:]
+	var code int
+	switch os.Args[1] {
+	case "encode":
+		code = encodeCommand(args)
+	case "decode":
+		code = decodeCommand(args)
+	case "verify":
+		code = verifyCommand(args)
+	case "repair":
+		code = repairCommand(args)
+	case "info":
+		code = infoCommand(args)
+	case "plan":
+		code = planCommand(args)
+	case "schedule":
+		code = scheduleCommand(args)
+	case "sim":
+		code = simCommand(args)
+	case "demo":
+		demo()
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		code = exitError
+	}
+	os.Exit(code)
+}
+
+func demo() {
 	r, err := raid6.BuildRaidSystem(5, 5)
 	raid6.CheckErr(err)
 
@@ -0,0 +1,183 @@
+package raid6
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"sort"
+)
+
+// AnalysisOptions bounds the work of AnalyzeCode.
+type AnalysisOptions struct {
+	// Limit is the number of combinations enumerated exhaustively for a
+	// given subset size; larger families are sampled. Zero means 100000.
+	Limit int
+	// Samples is the number of random subsets checked per size when
+	// sampling. Zero means Limit.
+	Samples int
+	Seed    int64
+	// MaxPatterns caps the number of reported subsets and patterns.
+	// Zero means 100.
+	MaxPatterns int
+}
+
+// CodeAnalysis is the result of AnalyzeCode. Shards are numbered by the
+// rows of the encoding matrix.
+type CodeAnalysis struct {
+	Rows       int
+	DataShards int
+
+	// SingularSubsets lists sets of DataShards rows whose submatrix
+	// cannot be inverted, out of SubsetsChecked sets checked. A code is
+	// MDS if no such set exists.
+	SubsetsChecked  int
+	SingularCount   int
+	SingularSubsets [][]int
+	MDS             bool
+
+	// FaultTolerance is the largest number of erasures that is always
+	// recoverable, and MinimumDistance is one more. Unrecoverable lists
+	// erasure patterns of size MinimumDistance that lose data; the
+	// surviving rows of such a pattern have rank below DataShards.
+	FaultTolerance  int
+	MinimumDistance int
+	Unrecoverable   [][]int
+
+	// Exhaustive is false if any family of subsets was sampled, in which
+	// case MDS and FaultTolerance are upper bounds.
+	Exhaustive bool
+}
+
+// errNotEncoding is returned if a matrix cannot be an encoding matrix.
+var errNotEncoding = errors.New("encoding matrix needs at least as many rows as data shards")
+
+// AnalyzeCode checks which erasure patterns an encoding matrix survives.
+// Every set of dataShards rows is checked for invertibility with Invert,
+// which decides whether ReconstructDataDisk works from exactly those
+// shards, and erasure patterns of growing size are checked for leaving
+// rows of full rank. It works for any matrix, including non-MDS codes.
+func AnalyzeCode(encoding [][]byte, dataShards int, opts AnalysisOptions) (CodeAnalysis, error) {
+	m := Matrix(encoding)
+	err := m.Check()
+	if err != nil {
+		return CodeAnalysis{}, err
+	}
+	if len(m[0]) != dataShards || len(m) < dataShards {
+		return CodeAnalysis{}, errNotEncoding
+	}
+	if len(m) > fieldSize {
+		return CodeAnalysis{}, errors.New("too many rows for an 8-bit field")
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 100000
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = opts.Limit
+	}
+	if opts.MaxPatterns <= 0 {
+		opts.MaxPatterns = 100
+	}
+
+	n := len(m)
+	a := CodeAnalysis{Rows: n, DataShards: dataShards, Exhaustive: true}
+	rng := rand.New(rand.NewSource(opts.Seed))
+
+	sub := make(Matrix, dataShards)
+	a.Exhaustive = subsets(n, dataShards, opts, rng, func(rows []int) bool {
+		for i, row := range rows {
+			sub[i] = m[row]
+		}
+		a.SubsetsChecked++
+		if _, err := sub.Invert(); err != nil {
+			a.SingularCount++
+			if len(a.SingularSubsets) < opts.MaxPatterns {
+				a.SingularSubsets = append(a.SingularSubsets, append([]int(nil), rows...))
+			}
+		}
+		return true
+	})
+	a.MDS = a.SingularCount == 0
+
+	// A pattern loses data if the surviving rows cannot span the data.
+	// The first size with such a pattern is the minimum distance.
+	a.FaultTolerance = n - dataShards
+	for size := 1; size <= n-dataShards; size++ {
+		exhaustive := subsets(n, size, opts, rng, func(erased []int) bool {
+			if len(a.Unrecoverable) >= opts.MaxPatterns {
+				return false
+			}
+			if survivors(m, erased).Rank() < dataShards {
+				a.Unrecoverable = append(a.Unrecoverable, append([]int(nil), erased...))
+			}
+			return true
+		})
+		// Stopping at MaxPatterns still proves the minimum distance.
+		a.Exhaustive = a.Exhaustive && (exhaustive || len(a.Unrecoverable) > 0)
+		if len(a.Unrecoverable) > 0 {
+			a.FaultTolerance = size - 1
+			break
+		}
+	}
+	a.MinimumDistance = a.FaultTolerance + 1
+	return a, nil
+}
+
+// Analyze runs AnalyzeCode on the encoding matrix of the codec.
+func (r *raid6) Analyze(opts AnalysisOptions) (CodeAnalysis, error) {
+	return AnalyzeCode(r.encodingMatrix, r.dataShards, opts)
+}
+
+// survivors returns the rows of m that are not erased.
+func survivors(m Matrix, erased []int) Matrix {
+	var rows Matrix
+	next := 0
+	for r, row := range m {
+		if next < len(erased) && erased[next] == r {
+			next++
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// subsets calls visit with every size-subset of 0..n-1 in ascending
+// order, or with opts.Samples random subsets if there are more than
+// opts.Limit of them, until visit returns false. It reports whether
+// every subset was visited, so it returns false if subsets were sampled
+// or visit stopped early.
+func subsets(n, size int, opts AnalysisOptions, rng *rand.Rand, visit func([]int) bool) bool {
+	count := new(big.Int).Binomial(int64(n), int64(size))
+	if count.Cmp(big.NewInt(int64(opts.Limit))) > 0 {
+		for i := 0; i < opts.Samples; i++ {
+			rows := rng.Perm(n)[:size]
+			sort.Ints(rows)
+			if !visit(rows) {
+				break
+			}
+		}
+		return false
+	}
+
+	rows := make([]int, size)
+	for i := range rows {
+		rows[i] = i
+	}
+	for {
+		if !visit(rows) {
+			return false
+		}
+		// Advance to the next combination in lexicographic order.
+		i := size - 1
+		for i >= 0 && rows[i] == n-size+i {
+			i--
+		}
+		if i < 0 {
+			return true
+		}
+		rows[i]++
+		for j := i + 1; j < size; j++ {
+			rows[j] = rows[j-1] + 1
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package raid6
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSubsets(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var seen [][]int
+	all := subsets(5, 2, AnalysisOptions{Limit: 100}, rng, func(rows []int) bool {
+		seen = append(seen, append([]int(nil), rows...))
+		return true
+	})
+	if !all || len(seen) != 10 || !reflect.DeepEqual(seen[0], []int{0, 1}) || !reflect.DeepEqual(seen[9], []int{3, 4}) {
+		t.Fatalf("exhaustive enumeration returned %v after %v", all, seen)
+	}
+
+	visited := 0
+	all = subsets(5, 2, AnalysisOptions{Limit: 100}, rng, func([]int) bool {
+		visited++
+		return visited < 3
+	})
+	if all || visited != 3 {
+		t.Fatalf("early stop after %d subsets reported %v", visited, all)
+	}
+
+	visited = 0
+	all = subsets(20, 10, AnalysisOptions{Limit: 100, Samples: 7}, rng, func([]int) bool {
+		visited++
+		return true
+	})
+	if all || visited != 7 {
+		t.Fatalf("sampling visited %d subsets and reported %v", visited, all)
+	}
+}
+
+// TestAnalyzeVandermonde checks that fixedVandermond and the systematic
+// encoding matrices the codec derives from it are MDS.
+func TestAnalyzeVandermonde(t *testing.T) {
+	for _, g := range []struct{ k, m int }{{2, 1}, {4, 2}, {6, 3}, {10, 4}} {
+		if a, err := AnalyzeCode(fixedVandermond(g.k+g.m, g.k), g.k, AnalysisOptions{}); err != nil || !a.MDS {
+			t.Fatalf("%d+%d: Vandermonde matrix not MDS: %v", g.k, g.m, err)
+		}
+		r, err := newRaid6(g.k, g.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := r.Analyze(AnalysisOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.MDS || !a.Exhaustive || a.SingularCount != 0 {
+			t.Fatalf("%d+%d: MDS %v, exhaustive %v, %d singular subsets", g.k, g.m, a.MDS, a.Exhaustive, a.SingularCount)
+		}
+		if a.FaultTolerance != g.m || a.MinimumDistance != g.m+1 || len(a.Unrecoverable) != 0 {
+			t.Fatalf("%d+%d: tolerance %d, distance %d", g.k, g.m, a.FaultTolerance, a.MinimumDistance)
+		}
+	}
+}
+
+// TestAnalyzeNonMDS checks a code whose first parity repeats data shard
+// 0: it survives any single erasure but not losing shards 1 and 3.
+func TestAnalyzeNonMDS(t *testing.T) {
+	code := [][]byte{{1, 0}, {0, 1}, {1, 0}, {1, 1}}
+	a, err := AnalyzeCode(code, 2, AnalysisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.MDS || a.SubsetsChecked != 6 || !reflect.DeepEqual(a.SingularSubsets, [][]int{{0, 2}}) {
+		t.Fatalf("MDS %v, singular %v of %d", a.MDS, a.SingularSubsets, a.SubsetsChecked)
+	}
+	if a.FaultTolerance != 1 || a.MinimumDistance != 2 || !reflect.DeepEqual(a.Unrecoverable, [][]int{{1, 3}}) {
+		t.Fatalf("tolerance %d, distance %d, unrecoverable %v", a.FaultTolerance, a.MinimumDistance, a.Unrecoverable)
+	}
+	if !a.Exhaustive {
+		t.Fatal("small code not analyzed exhaustively")
+	}
+
+	// Two repeated pairs: stopping at the first unrecoverable pattern
+	// still proves the distance.
+	code = [][]byte{{1, 0}, {1, 0}, {0, 1}, {0, 1}}
+	a, err = AnalyzeCode(code, 2, AnalysisOptions{MaxPatterns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.MinimumDistance != 2 || len(a.Unrecoverable) != 1 || !a.Exhaustive {
+		t.Fatalf("distance %d, unrecoverable %v, exhaustive %v", a.MinimumDistance, a.Unrecoverable, a.Exhaustive)
+	}
+}
@@ -0,0 +1,440 @@
+package raid6
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ArrayKind selects an XOR-only array code.
+type ArrayKind int
+
+const (
+	// ArrayEvenOdd is EVENODD: row parity plus diagonal parity adjusted
+	// by the missing diagonal, over a prime p >= dataShards.
+	ArrayEvenOdd ArrayKind = iota
+	// ArrayRDP is Row-Diagonal Parity: row parity plus diagonal parity
+	// over the data and row parity columns, with a prime p > dataShards.
+	ArrayRDP
+	// ArraySTAR extends EVENODD with an adjusted anti-diagonal parity and
+	// tolerates three failures.
+	ArraySTAR
+)
+
+var arrayNames = []string{
+	ArrayEvenOdd: "EVENODD",
+	ArrayRDP:     "RDP",
+	ArraySTAR:    "STAR",
+}
+
+func (k ArrayKind) String() string {
+	if k < 0 || int(k) >= len(arrayNames) {
+		return fmt.Sprintf("array(%d)", int(k))
+	}
+	return arrayNames[k]
+}
+
+// ArrayCode is an array code that computes parity with XOR alone, with
+// the same Encode, Verify and ReconstructDisk methods as the Reed-Solomon
+// codec. Every shard is a column of rows symbols, rows = p-1, so shard
+// lengths must be a multiple of rows. Shards are ordered data, then
+// parity. Data columns up to the prime that are not used are taken as
+// zero, which is how any number of data shards is supported.
+//
+// The code is described by its parity equations, each a set of symbols
+// whose XOR is zero. Decoding an erasure pattern solves those equations
+// for the erased symbols over GF(2) once and caches the result as an XOR
+// schedule, so encoding and every tolerated pattern use the same path.
+type ArrayCode struct {
+	kind         ArrayKind
+	prime        int
+	rows         int
+	dataShards   int
+	parityShards int
+	totalShards  int
+
+	// equations lists, per parity equation, the symbols it XORs.
+	// Symbol s is row s%rows of shard s/rows.
+	equations [][]int
+	schedules map[string][][]int
+
+	DiskArray Matrix
+}
+
+// errUnrecoverable is returned if an erasure pattern exceeds the code.
+var errUnrecoverable = errors.New("erasure pattern cannot be recovered")
+
+// NewArrayCode returns an array code of the given kind for dataShards
+// data shards, using the smallest prime that fits.
+func NewArrayCode(kind ArrayKind, dataShards int) (*ArrayCode, error) {
+	if dataShards < 2 {
+		return nil, errors.New("array codes need at least two data shards")
+	}
+	c := &ArrayCode{kind: kind, dataShards: dataShards, schedules: make(map[string][][]int)}
+	switch kind {
+	case ArrayEvenOdd:
+		c.prime = nextPrime(dataShards)
+		c.parityShards = 2
+	case ArrayRDP:
+		c.prime = nextPrime(dataShards + 1)
+		c.parityShards = 2
+	case ArraySTAR:
+		c.prime = nextPrime(dataShards)
+		c.parityShards = 3
+	default:
+		return nil, fmt.Errorf("unknown array code %d", int(kind))
+	}
+	c.rows = c.prime - 1
+	c.totalShards = dataShards + c.parityShards
+	c.buildEquations()
+	return c, nil
+}
+
+// nextPrime returns the smallest prime >= n, and at least 3.
+func nextPrime(n int) int {
+	if n < 3 {
+		n = 3
+	}
+	for ; ; n++ {
+		prime := true
+		for d := 2; d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// Prime returns the prime the code is built on.
+func (c *ArrayCode) Prime() int {
+	return c.prime
+}
+
+// Kind returns the kind of array code.
+func (c *ArrayCode) Kind() ArrayKind {
+	return c.kind
+}
+
+// Rows returns the number of symbols per shard.
+func (c *ArrayCode) Rows() int {
+	return c.rows
+}
+
+// equation collects the symbols of one parity equation. A symbol added
+// twice cancels, as it does in the XOR. Rows and columns outside the
+// array, the imaginary zero row p-1 and unused data columns, are dropped.
+type equation map[int]bool
+
+func (c *ArrayCode) add(e equation, row, col int) {
+	if row < 0 || row >= c.rows || col < 0 || col >= c.totalShards {
+		return
+	}
+	s := col*c.rows + row
+	e[s] = !e[s]
+}
+
+// dataCol returns the shard of data column j of the code, or -1 if the
+// column is one of the unused zero columns.
+func (c *ArrayCode) dataCol(j int) int {
+	if j < c.dataShards {
+		return j
+	}
+	return -1
+}
+
+func (c *ArrayCode) buildEquations() {
+	p := c.prime
+	k := c.dataShards
+	var eqs []equation
+	mod := func(x int) int { return ((x % p) + p) % p }
+
+	// Data columns 0..width-1 take part in the parities.
+	width := p
+	if c.kind == ArrayRDP {
+		width = p - 1
+	}
+	addData := func(e equation, row, j int) {
+		if col := c.dataCol(j); col >= 0 {
+			c.add(e, row, col)
+		}
+	}
+
+	// Row parity, stored in shard k.
+	for i := 0; i < c.rows; i++ {
+		e := equation{}
+		c.add(e, i, k)
+		for j := 0; j < width; j++ {
+			addData(e, i, j)
+		}
+		eqs = append(eqs, e)
+	}
+
+	switch c.kind {
+	case ArrayEvenOdd, ArraySTAR:
+		// Diagonal i holds d[i-j][j]; every stored diagonal parity also
+		// includes S, the XOR of the missing diagonal p-1.
+		adjusted := func(shard int, slope int) {
+			for i := 0; i < c.rows; i++ {
+				e := equation{}
+				c.add(e, i, shard)
+				for j := 0; j < p; j++ {
+					addData(e, mod(i-slope*j), j)
+					addData(e, mod(p-1-slope*j), j)
+				}
+				eqs = append(eqs, e)
+			}
+		}
+		adjusted(k+1, 1)
+		if c.kind == ArraySTAR {
+			adjusted(k+2, -1)
+		}
+	case ArrayRDP:
+		// Diagonal d holds the symbols with (row + column) mod p = d over
+		// the data and row parity columns; diagonal p-1 is not stored.
+		for d := 0; d < c.rows; d++ {
+			e := equation{}
+			c.add(e, d, k+1)
+			for j := 0; j < p; j++ {
+				row := mod(d - j)
+				if j == p-1 {
+					c.add(e, row, k)
+				} else {
+					addData(e, row, j)
+				}
+			}
+			eqs = append(eqs, e)
+		}
+	}
+
+	for _, e := range eqs {
+		var symbols []int
+		for s, in := range e {
+			if in {
+				symbols = append(symbols, s)
+			}
+		}
+		sort.Ints(symbols)
+		c.equations = append(c.equations, symbols)
+	}
+}
+
+// schedule returns, for every symbol of the erased shards in order, the
+// known symbols whose XOR gives it. It is computed by Gaussian
+// elimination over GF(2) on the equations restricted to the erased
+// symbols, keeping track of the known symbols each combination sums.
+func (c *ArrayCode) schedule(erased []int) ([][]int, error) {
+	key := fmt.Sprint(erased)
+	if s, ok := c.schedules[key]; ok {
+		return s, nil
+	}
+
+	unknown := make(map[int]int)
+	for _, shard := range erased {
+		for r := 0; r < c.rows; r++ {
+			unknown[shard*c.rows+r] = len(unknown)
+		}
+	}
+	nSymbols := c.totalShards * c.rows
+	words := func(n int) int { return (n + 63) / 64 }
+
+	// Each row: bits over unknowns, bits over known symbols.
+	type row struct{ u, k []uint64 }
+	var system []row
+	for _, eq := range c.equations {
+		r := row{make([]uint64, words(len(unknown))), make([]uint64, words(nSymbols))}
+		touched := false
+		for _, s := range eq {
+			if u, ok := unknown[s]; ok {
+				r.u[u/64] ^= 1 << (u % 64)
+				touched = true
+			} else {
+				r.k[s/64] ^= 1 << (s % 64)
+			}
+		}
+		if touched {
+			system = append(system, r)
+		}
+	}
+
+	pivotRow := make([]int, len(unknown))
+	next := 0
+	for u := range pivotRow {
+		found := -1
+		for i := next; i < len(system); i++ {
+			if system[i].u[u/64]&(1<<(u%64)) != 0 {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return nil, errUnrecoverable
+		}
+		system[next], system[found] = system[found], system[next]
+		for i := range system {
+			if i == next || system[i].u[u/64]&(1<<(u%64)) == 0 {
+				continue
+			}
+			for w := range system[i].u {
+				system[i].u[w] ^= system[next].u[w]
+			}
+			for w := range system[i].k {
+				system[i].k[w] ^= system[next].k[w]
+			}
+		}
+		pivotRow[u] = next
+		next++
+	}
+
+	s := make([][]int, len(unknown))
+	for u := range s {
+		k := system[pivotRow[u]].k
+		for sym := 0; sym < nSymbols; sym++ {
+			if k[sym/64]&(1<<(sym%64)) != 0 {
+				s[u] = append(s[u], sym)
+			}
+		}
+	}
+	c.schedules[key] = s
+	return s, nil
+}
+
+// symbol returns symbol s of shards.
+func (c *ArrayCode) symbol(shards Matrix, s int) []byte {
+	size := len(shards[s/c.rows]) / c.rows
+	row := s % c.rows
+	return shards[s/c.rows][row*size : (row+1)*size]
+}
+
+// solve fills the erased shards from the others.
+func (c *ArrayCode) solve(shards Matrix, erased []int, size int) error {
+	s, err := c.schedule(erased)
+	if err != nil {
+		return err
+	}
+	for _, shard := range erased {
+		shards[shard] = make([]byte, size)
+	}
+	for i, shard := range erased {
+		for r := 0; r < c.rows; r++ {
+			dst := c.symbol(shards, shard*c.rows+r)
+			for _, src := range s[i*c.rows+r] {
+				xorSlice(dst, c.symbol(shards, src))
+			}
+		}
+	}
+	return nil
+}
+
+// xorSlice sets dst ^= src, eight bytes at a time.
+func xorSlice(dst, src []byte) {
+	n := len(dst) &^ 7
+	for i := 0; i < n; i += 8 {
+		v := binary.LittleEndian.Uint64(dst[i:]) ^ binary.LittleEndian.Uint64(src[i:])
+		binary.LittleEndian.PutUint64(dst[i:], v)
+	}
+	for i := n; i < len(dst); i++ {
+		dst[i] ^= src[i]
+	}
+}
+
+// Encode computes the parity shards of the data shards and stores all
+// shards in DiskArray. Shard lengths must be equal and a multiple of Rows.
+func (c *ArrayCode) Encode(shards [][]byte) error {
+	if len(shards) != c.dataShards {
+		return fmt.Errorf("need %d data shards, got %d", c.dataShards, len(shards))
+	}
+	size := len(shards[0])
+	for _, shard := range shards {
+		if len(shard) != size || size == 0 || size%c.rows != 0 {
+			return fmt.Errorf("shards must have equal length, a multiple of %d", c.rows)
+		}
+	}
+	disks := make(Matrix, c.totalShards)
+	copy(disks, shards)
+	parity := make([]int, c.parityShards)
+	for j := range parity {
+		parity[j] = c.dataShards + j
+	}
+	err := c.solve(disks, parity, size)
+	if err != nil {
+		return err
+	}
+	c.DiskArray = disks
+	return nil
+}
+
+// Verify recomputes the parity shards from the data shards and reports
+// which stored parity shards match, like the Reed-Solomon Verify.
+func (c *ArrayCode) Verify() ([]bool, Matrix) {
+	calculated := make([]bool, c.parityShards)
+	saved := c.DiskArray
+	err := c.Encode(saved[:c.dataShards])
+	encoded := c.DiskArray
+	c.DiskArray = saved
+	if err != nil {
+		return calculated, nil
+	}
+	for j := range calculated {
+		calculated[j] = bytes.Equal(saved[c.dataShards+j], encoded[c.dataShards+j])
+	}
+	return calculated, encoded[c.dataShards:]
+}
+
+// ReconstructDisk rebuilds every nil shard of DiskArray.
+func (c *ArrayCode) ReconstructDisk() error {
+	if len(c.DiskArray) != c.totalShards {
+		return errors.New("invalid disk array")
+	}
+	var erased []int
+	size := -1
+	for i, shard := range c.DiskArray {
+		if shard == nil {
+			erased = append(erased, i)
+		} else {
+			size = len(shard)
+		}
+	}
+	if len(erased) == 0 {
+		return nil
+	}
+	if len(erased) > c.parityShards || size < 0 {
+		return errUnrecoverable
+	}
+	return c.solve(c.DiskArray, erased, size)
+}
+
+// DropShard erases a shard to simulate a failed disk.
+func (c *ArrayCode) DropShard(nShard int) error {
+	if nShard < 0 || nShard >= len(c.DiskArray) {
+		return errors.New("invalid shard number")
+	}
+	c.DiskArray[nShard] = nil
+	return nil
+}
+
+// ScheduleCost returns the number of symbol XORs needed to rebuild the
+// given erased shards, a measure of the decoding work.
+func (c *ArrayCode) ScheduleCost(erased ...int) (int, error) {
+	erased = append([]int(nil), erased...)
+	sort.Ints(erased)
+	s, err := c.schedule(erased)
+	if err != nil {
+		return 0, err
+	}
+	cost := 0
+	for _, sources := range s {
+		cost += len(sources)
+	}
+	return cost, nil
+}
+
+// String describes the code, e.g. "RDP(k=6, p=7)".
+func (c *ArrayCode) String() string {
+	return fmt.Sprintf("%s(k=%d, p=%d)", c.kind, c.dataShards, c.prime)
+}
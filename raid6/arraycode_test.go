@@ -0,0 +1,79 @@
+package raid6
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestArrayCodeReconstruct(t *testing.T) {
+	for _, kind := range []ArrayKind{ArrayEvenOdd, ArrayRDP, ArraySTAR} {
+		for _, k := range []int{2, 4, 5, 6} {
+			t.Run(fmt.Sprintf("%v-%d", kind, k), func(t *testing.T) {
+				c, err := NewArrayCode(kind, k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rng := rand.New(rand.NewSource(int64(k)))
+				data := randomShards(rng, k, 4*c.Rows())
+				if err := c.Encode(data); err != nil {
+					t.Fatal(err)
+				}
+				checkShards(t, c.DiskArray[:k], data)
+				if ok, _ := c.Verify(); !all(ok) {
+					t.Fatalf("verify %v after encode", ok)
+				}
+				want := append(Matrix(nil), c.DiskArray...)
+				n := len(want)
+				m := n - k
+
+				// Every tolerated pattern.
+				for size := 1; size <= m; size++ {
+					subsets(n, size, AnalysisOptions{Limit: 1000}, rng, func(lost []int) bool {
+						c.DiskArray = append(Matrix(nil), want...)
+						for _, i := range lost {
+							c.DiskArray[i] = nil
+						}
+						if err := c.ReconstructDisk(); err != nil {
+							t.Fatalf("erasing %v: %v", lost, err)
+						}
+						checkShards(t, c.DiskArray, want)
+						return true
+					})
+				}
+
+				c.DiskArray = append(Matrix(nil), want...)
+				for i := 0; i <= m; i++ {
+					c.DiskArray[i] = nil
+				}
+				if err := c.ReconstructDisk(); err == nil {
+					t.Fatalf("%d erasures reconstructed", m+1)
+				}
+			})
+		}
+	}
+}
+
+// all reports whether every entry of ok is true.
+func all(ok []bool) bool {
+	for _, v := range ok {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestScheduleCostKeepsArguments(t *testing.T) {
+	c, err := NewArrayCode(ArrayRDP, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	erased := []int{5, 1}
+	if _, err := c.ScheduleCost(erased...); err != nil {
+		t.Fatal(err)
+	}
+	if erased[0] != 5 || erased[1] != 1 {
+		t.Fatalf("ScheduleCost reordered its arguments to %v", erased)
+	}
+}
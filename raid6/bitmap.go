@@ -0,0 +1,224 @@
+package raid6
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// The write-intent bitmap has one bit per region of regionStripes stripes.
+// A region's bit is persisted on every member before the first write to
+// it and cleared lazily once writes have moved elsewhere, so after an
+// unclean shutdown only regions with their bit set can hold stripes whose
+// parity does not match their data. Assemble re-verifies just those.
+//
+// The bitmap block follows the superblock on each member:
+//
+//	[magic 4][events 8][region stripes 4][bits ...][crc 4]
+
+const (
+	bitmapMagic  = 0x4d364452 // "RD6M"
+	bitmapOffset = superblockSize
+	bitmapSize   = 4096
+
+	bitmapHeaderSize = 16
+	bitmapBytes      = bitmapSize - 4 - bitmapHeaderSize
+
+	// bitmapFlushWrites is the number of stripe writes after which bits
+	// of idle regions are cleared.
+	bitmapFlushWrites = 128
+)
+
+// errNoBitmap is returned if a member does not carry a valid write-intent bitmap.
+var errNoBitmap = errors.New("no valid write-intent bitmap")
+
+// intentRegion returns the bitmap bit covering stripe, clamped like regionOf.
+func (v *Volume) intentRegion(stripe int64) int {
+	region := stripe / int64(v.regionStripes)
+	if region >= bitmapBytes*8 {
+		return bitmapBytes*8 - 1
+	}
+	return int(region)
+}
+
+// markIntent sets the bit of the region holding stripe and persists the
+// bitmap if the bit was clear. Every bitmapFlushWrites writes the bits of
+// all other regions are dropped; writes are serialized, so those regions
+// have no writes in flight.
+func (v *Volume) markIntent(stripe int64) error {
+	region := v.intentRegion(stripe)
+	v.intentWrites++
+	changed := false
+	if v.intentWrites >= bitmapFlushWrites {
+		v.intentWrites = 0
+		for i := range v.intent {
+			if v.intent[i] != 0 {
+				v.intent[i] = 0
+				changed = true
+			}
+		}
+	}
+	if v.intent[region/8]&(1<<(region%8)) == 0 {
+		v.intent[region/8] |= 1 << (region % 8)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return v.writeBitmap()
+}
+
+// writeBitmap stores the bitmap on every present member and syncs it.
+func (v *Volume) writeBitmap() error {
+	if !v.hasSuperblock {
+		return nil
+	}
+	buf := make([]byte, bitmapSize)
+	binary.LittleEndian.PutUint32(buf[0:4], bitmapMagic)
+	binary.LittleEndian.PutUint64(buf[4:12], v.events)
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(v.regionStripes))
+	copy(buf[bitmapHeaderSize:bitmapSize-4], v.intent)
+	crc := crc32.ChecksumIEEE(buf[:bitmapSize-4])
+	binary.LittleEndian.PutUint32(buf[bitmapSize-4:], crc)
+
+	for i, d := range v.members() {
+		if d == nil {
+			continue
+		}
+		_, err := d.WriteAt(buf, bitmapOffset)
+		if err == nil {
+			err = d.Sync()
+		}
+		if err != nil {
+			return fmt.Errorf("disk %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// readBitmap returns the bitmap bits and the event counter stored on d.
+func readBitmap(d Disk) ([]byte, uint64, error) {
+	buf := make([]byte, bitmapSize)
+	err := readFull(d, buf, bitmapOffset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(buf[0:4]) != bitmapMagic {
+		return nil, 0, errNoBitmap
+	}
+	crc := crc32.ChecksumIEEE(buf[:bitmapSize-4])
+	if crc != binary.LittleEndian.Uint32(buf[bitmapSize-4:]) {
+		return nil, 0, errNoBitmap
+	}
+	events := binary.LittleEndian.Uint64(buf[4:12])
+	return buf[bitmapHeaderSize : bitmapSize-4], events, nil
+}
+
+// loadBitmap reads the freshest bitmap among the present members.
+// A member without a readable bitmap is ignored; if none has one, every
+// region is marked, which turns the resync into a full verification.
+func (v *Volume) loadBitmap() {
+	var newest uint64
+	found := false
+	for _, d := range v.disks {
+		if d == nil {
+			continue
+		}
+		bits, events, err := readBitmap(d)
+		if err != nil || (found && events <= newest) {
+			continue
+		}
+		copy(v.intent, bits)
+		newest = events
+		found = true
+	}
+	if !found {
+		for i := range v.intent {
+			v.intent[i] = 0xff
+		}
+	}
+}
+
+// resyncIntent re-verifies every stripe in a region marked in the bitmap
+// and rewrites parity that does not match the data. It returns the number
+// of stripes repaired and clears the bitmap afterwards.
+func (v *Volume) resyncIntent() (int, error) {
+	count, err := v.stripeCount()
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+	regions := bitmapBytes * 8
+	for region := 0; region < regions; region++ {
+		if v.intent[region/8]&(1<<(region%8)) == 0 {
+			continue
+		}
+		start := int64(region) * int64(v.regionStripes)
+		end := start + int64(v.regionStripes)
+		if region == regions-1 || end > count {
+			end = count
+		}
+		for stripe := start; stripe < end; stripe++ {
+			fixed, err := v.repairParity(stripe)
+			if err != nil {
+				return repaired, err
+			}
+			if fixed {
+				repaired++
+			}
+		}
+	}
+
+	for i := range v.intent {
+		v.intent[i] = 0
+	}
+	return repaired, v.writeBitmap()
+}
+
+// repairParity verifies a stripe and rewrites parity chunks that do not
+// match its data. After a crash the data chunks are taken as the truth;
+// the journal is what preserves the previous contents of a stripe.
+// Stripes with a missing data member cannot be verified and are skipped.
+func (v *Volume) repairParity(stripe int64) (bool, error) {
+	g := v.layoutFor(stripe)
+	shards := g.readChunks(stripe)
+	for i := 0; i < g.r.dataShards; i++ {
+		if shards[i] == nil {
+			return false, nil
+		}
+	}
+	valid, calculated := g.r.codec(shards).Verify()
+
+	fixed := false
+	offset := g.chunkOffset(stripe)
+	disks := g.layout.Map(stripe, g.r.dataShards, g.r.parityShards)
+	for i, ok := range valid {
+		d := g.disks[disks[g.r.dataShards+i]]
+		if ok || d == nil {
+			continue
+		}
+		_, err := d.WriteAt(calculated[i], offset)
+		if err != nil {
+			return false, fmt.Errorf("disk %d: %w", disks[g.r.dataShards+i], err)
+		}
+		fixed = true
+	}
+	if !fixed {
+		return false, nil
+	}
+	// The bitmap bit covering this stripe is cleared once the region is
+	// done, so the repaired parity has to be stable before that.
+	for i, ok := range valid {
+		d := g.disks[disks[g.r.dataShards+i]]
+		if ok || d == nil {
+			continue
+		}
+		err := d.Sync()
+		if err != nil {
+			return false, fmt.Errorf("disk %d: %w", disks[g.r.dataShards+i], err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package raid6
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// TestUncleanShutdownIntent leaves an array active with a torn parity
+// write in a region whose intent bit is set and another in a region whose
+// bit is clear. Assemble must repair the first and, since it verifies only
+// marked regions, leave the second alone.
+func TestUncleanShutdownIntent(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 3*defaultRegionStripes*256)
+	rng.Read(data)
+	paths := createArray(t, t.TempDir(), data)
+
+	v, err := Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked, unmarked := int64(defaultRegionStripes+6), int64(2*defaultRegionStripes+6)
+	update := make([]byte, 256)
+	rng.Read(update)
+	if _, err := v.WriteAt(update, marked*256); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.intent[0]; got != 1<<1 {
+		t.Fatalf("intent bitmap %08b, want only region 1", got)
+	}
+
+	// Tear a parity chunk in both stripes, then lose power: the members
+	// are closed without marking the array clean.
+	tear := func(stripe int64) {
+		disk := v.ParityLocations(stripe)[0]
+		f, err := os.OpenFile(paths[disk], os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt(bytes.Repeat([]byte{0xff}, 32), v.chunkOffset(stripe)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tear(marked)
+	tear(unmarked)
+	for _, d := range v.disks {
+		d.Close()
+	}
+
+	v, err = Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := v.VerifyStripe(marked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all(valid) {
+		t.Fatal("parity of a marked region not repaired")
+	}
+	valid, err = v.VerifyStripe(unmarked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all(valid) {
+		t.Fatal("unmarked region was verified")
+	}
+	if bytes.Count(v.intent, []byte{0}) != len(v.intent) {
+		t.Fatal("intent bitmap not cleared after resync")
+	}
+	got := make([]byte, 256)
+	if _, err := v.ReadAt(got, marked*256); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, update) {
+		t.Fatal("repair changed the data of a marked stripe")
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
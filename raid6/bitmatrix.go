@@ -0,0 +1,547 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// The bit-matrix representation replaces every element e of a GF(2^8)
+// matrix with the 8x8 binary matrix of multiplication by e: column c
+// holds the bits of e*2^c. A shard is then split into 8 packets, packet c
+// carrying bit c of every symbol, and multiplying by the matrix becomes
+// XORing whole packets, with no table lookups at all. The parity is that
+// of the same code, only laid out by bit plane instead of by byte.
+//
+// Encoding one output packet costs one XOR less than the ones in its bit
+// row. Schedules cut that down in two ways: smart scheduling builds a
+// row from an already computed row when they differ in fewer bits, and
+// common-subexpression elimination computes a pair of packets shared by
+// many rows once.
+
+// BitMatrix is a binary matrix with one byte, 0 or 1, per entry.
+type BitMatrix [][]byte
+
+// VandermondeMatrix returns the systematic Vandermonde encoding matrix
+// the Reed-Solomon codec uses.
+func VandermondeMatrix(dataShards, parityShards int) (Matrix, error) {
+	r, err := newRaid6(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	return r.encodingMatrix, nil
+}
+
+// CauchyMatrix returns a systematic Cauchy encoding matrix: identity rows
+// over parity rows 1/(x_i + y_j) with x_i = i and y_j = parityShards + j.
+// Every square submatrix of a Cauchy matrix is invertible, and scaling
+// its rows or columns keeps it so. The columns are scaled to make the
+// first parity row all ones, plain XOR parity, and every other row by
+// the factor that leaves the fewest ones in its bit-matrix.
+func CauchyMatrix(dataShards, parityShards int) (Matrix, error) {
+	if dataShards <= 0 || parityShards <= 0 {
+		return nil, errors.New("invalid data or parity shards")
+	}
+	if dataShards+parityShards > fieldSize {
+		return nil, errors.New("too many shards for an 8-bit field")
+	}
+	m, _ := NewMatrix(dataShards+parityShards, dataShards)
+	for i := 0; i < dataShards; i++ {
+		m[i][i] = 1
+	}
+	parity := m[dataShards:]
+	for i := range parity {
+		for j := range parity[i] {
+			parity[i][j] = galOneOver(byte(i) ^ byte(parityShards+j))
+		}
+	}
+	for j := range parity[0] {
+		scale := galOneOver(parity[0][j])
+		for i := range parity {
+			parity[i][j] = galMultiply(parity[i][j], scale)
+		}
+	}
+	for i := 1; i < parityShards; i++ {
+		best, bestOnes := byte(1), -1
+		for f := 1; f < fieldSize; f++ {
+			ones := 0
+			for _, e := range parity[i] {
+				ones += ExpandBits(Matrix{{galMultiply(e, byte(f))}}).Ones()
+			}
+			if bestOnes < 0 || ones < bestOnes {
+				best, bestOnes = byte(f), ones
+			}
+		}
+		for j := range parity[i] {
+			parity[i][j] = galMultiply(parity[i][j], best)
+		}
+	}
+	return m, nil
+}
+
+// ExpandBits returns the 8*rows by 8*cols bit-matrix of m.
+func ExpandBits(m Matrix) BitMatrix {
+	b := make(BitMatrix, 8*len(m))
+	for i := range b {
+		b[i] = make([]byte, 8*len(m[0]))
+	}
+	for i, row := range m {
+		for j, e := range row {
+			for c := 0; c < 8; c++ {
+				v := galMultiply(e, byte(1)<<c)
+				for r := 0; r < 8; r++ {
+					b[8*i+r][8*j+c] = v >> r & 1
+				}
+			}
+		}
+	}
+	return b
+}
+
+// Ones returns the number of ones in b.
+func (b BitMatrix) Ones() int {
+	n := 0
+	for _, row := range b {
+		for _, v := range row {
+			n += int(v)
+		}
+	}
+	return n
+}
+
+// XOROp is one step of a schedule: packet Dst is set to packet Src if
+// Copy is set, and XORed with it otherwise. Packets are numbered inputs
+// first, then outputs, then temporaries.
+type XOROp struct {
+	Src, Dst int
+	Copy     bool
+}
+
+// XORSchedule computes the outputs of a bit-matrix from its inputs.
+type XORSchedule struct {
+	Inputs  int
+	Outputs int
+	Temps   int
+	Ops     []XOROp
+}
+
+// XORs returns the number of XOR operations in the schedule.
+func (s *XORSchedule) XORs() int {
+	n := 0
+	for _, op := range s.Ops {
+		if !op.Copy {
+			n++
+		}
+	}
+	return n
+}
+
+// packetSet is a set of packets as a bitset.
+type packetSet []uint64
+
+func newPacketSet(n int) packetSet {
+	return make(packetSet, (n+63)/64)
+}
+
+func (p packetSet) has(i int) bool { return p[i/64]&(1<<(i%64)) != 0 }
+func (p packetSet) set(i int)      { p[i/64] |= 1 << (i % 64) }
+
+func (p packetSet) count() int {
+	n := 0
+	for _, w := range p {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// and returns the size of the intersection of p and q.
+func (p packetSet) and(q packetSet) int {
+	n := 0
+	for i := range p {
+		n += bits.OnesCount64(p[i] & q[i])
+	}
+	return n
+}
+
+// diff returns the size of the symmetric difference of p and q.
+func (p packetSet) diff(q packetSet) int {
+	n := 0
+	for i := range p {
+		n += bits.OnesCount64(p[i] ^ q[i])
+	}
+	return n
+}
+
+// members returns the packets of p in increasing order.
+func (p packetSet) members() []int {
+	var m []int
+	for w, v := range p {
+		for v != 0 {
+			m = append(m, w*64+bits.TrailingZeros64(v))
+			v &= v - 1
+		}
+	}
+	return m
+}
+
+// bitRows returns the rows of b as packet sets over its inputs.
+func bitRows(b BitMatrix, size int) []packetSet {
+	rows := make([]packetSet, len(b))
+	for i, row := range b {
+		rows[i] = newPacketSet(size)
+		for j, v := range row {
+			if v != 0 {
+				rows[i].set(j)
+			}
+		}
+	}
+	return rows
+}
+
+// sumOps appends the ops that set dst to the XOR of packets.
+func sumOps(ops []XOROp, dst int, packets []int) []XOROp {
+	for i, src := range packets {
+		ops = append(ops, XOROp{Src: src, Dst: dst, Copy: i == 0})
+	}
+	return ops
+}
+
+// NaiveSchedule computes every output row from its inputs alone.
+func NaiveSchedule(b BitMatrix) *XORSchedule {
+	s := &XORSchedule{Inputs: len(b[0]), Outputs: len(b)}
+	for i, row := range bitRows(b, s.Inputs) {
+		s.Ops = sumOps(s.Ops, s.Inputs+i, row.members())
+	}
+	return s
+}
+
+// SmartSchedule computes the rows in order of increasing cost, building
+// a row from an already computed one whenever they differ in fewer bits
+// than the row has ones, as Jerasure's smart scheduling does.
+func SmartSchedule(b BitMatrix) *XORSchedule {
+	s := &XORSchedule{Inputs: len(b[0]), Outputs: len(b)}
+	rows := bitRows(b, s.Inputs)
+	done := make([]bool, len(rows))
+	cost := make([]int, len(rows))
+	from := make([]int, len(rows))
+	for i, row := range rows {
+		cost[i] = row.count()
+		from[i] = -1
+	}
+	for range rows {
+		next := -1
+		for i := range rows {
+			if !done[i] && (next < 0 || cost[i] < cost[next]) {
+				next = i
+			}
+		}
+		done[next] = true
+		dst := s.Inputs + next
+		if from[next] < 0 {
+			s.Ops = sumOps(s.Ops, dst, rows[next].members())
+		} else {
+			base := rows[from[next]]
+			s.Ops = append(s.Ops, XOROp{Src: s.Inputs + from[next], Dst: dst, Copy: true})
+			for _, j := range rows[next].members() {
+				if !base.has(j) {
+					s.Ops = append(s.Ops, XOROp{Src: j, Dst: dst})
+				}
+			}
+			for _, j := range base.members() {
+				if !rows[next].has(j) {
+					s.Ops = append(s.Ops, XOROp{Src: j, Dst: dst})
+				}
+			}
+		}
+		for i := range rows {
+			if !done[i] {
+				if d := rows[i].diff(rows[next]); d < cost[i] {
+					cost[i] = d
+					from[i] = next
+				}
+			}
+		}
+	}
+	return s
+}
+
+// CSESchedule repeatedly finds the pair of packets that occurs together
+// in the most rows, computes it once into a temporary and substitutes it,
+// until no pair is shared by two rows (Paar's greedy algorithm).
+func CSESchedule(b BitMatrix) *XORSchedule {
+	s := &XORSchedule{Inputs: len(b[0]), Outputs: len(b)}
+	// Temporaries are numbered after the outputs. Each packet keeps the
+	// set of rows it occurs in, so a pair occurs in the rows of the
+	// intersection.
+	var cols []packetSet
+	var ids []int
+	for j := 0; j < s.Inputs; j++ {
+		col := newPacketSet(len(b))
+		for i, row := range b {
+			if row[j] != 0 {
+				col.set(i)
+			}
+		}
+		cols = append(cols, col)
+		ids = append(ids, j)
+	}
+	for {
+		bx, by, bestCount := -1, -1, 1
+		counts := make([]int, len(cols))
+		for x, col := range cols {
+			counts[x] = col.count()
+		}
+		for x := range cols {
+			if counts[x] <= bestCount {
+				continue
+			}
+			for y := x + 1; y < len(cols); y++ {
+				if counts[y] <= bestCount {
+					continue
+				}
+				if c := cols[x].and(cols[y]); c > bestCount {
+					bx, by, bestCount = x, y, c
+				}
+			}
+		}
+		if bestCount < 2 {
+			break
+		}
+		t := s.Inputs + s.Outputs + s.Temps
+		s.Temps++
+		s.Ops = append(s.Ops, XOROp{Src: ids[bx], Dst: t, Copy: true}, XOROp{Src: ids[by], Dst: t})
+		col := newPacketSet(len(b))
+		for w := range col {
+			col[w] = cols[bx][w] & cols[by][w]
+			cols[bx][w] &^= col[w]
+			cols[by][w] &^= col[w]
+		}
+		cols = append(cols, col)
+		ids = append(ids, t)
+	}
+
+	rows := make([][]int, len(b))
+	for x, col := range cols {
+		for _, i := range col.members() {
+			rows[i] = append(rows[i], ids[x])
+		}
+	}
+	for i, packets := range rows {
+		s.Ops = sumOps(s.Ops, s.Inputs+i, packets)
+	}
+	return s
+}
+
+// ScheduleReport compares the XOR cost of the schedules of a matrix.
+type ScheduleReport struct {
+	Rows, Cols int // of the GF(2^8) matrix
+	Ones       int
+	Naive      int
+	Smart      int
+	CSE        int
+}
+
+// Best returns the cost of the cheapest schedule.
+func (r ScheduleReport) Best() int {
+	return min(r.Naive, r.Smart, r.CSE)
+}
+
+func (r ScheduleReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%dx%d matrix, %d ones in bit-matrix \n", r.Rows, r.Cols, r.Ones)
+	fmt.Fprintf(&b, "naive: %d XORs \n", r.Naive)
+	fmt.Fprintf(&b, "smart: %d XORs \n", r.Smart)
+	fmt.Fprintf(&b, "cse:   %d XORs \n", r.CSE)
+	fmt.Fprintf(&b, "best: %.0f%% of naive \n", 100*float64(r.Best())/float64(r.Naive))
+	return b.String()
+}
+
+// ReportSchedule returns the schedule costs of multiplying by m.
+func ReportSchedule(m Matrix) ScheduleReport {
+	b := ExpandBits(m)
+	return ScheduleReport{
+		Rows:  len(m),
+		Cols:  len(m[0]),
+		Ones:  b.Ones(),
+		Naive: NaiveSchedule(b).XORs(),
+		Smart: SmartSchedule(b).XORs(),
+		CSE:   CSESchedule(b).XORs(),
+	}
+}
+
+// BestSchedule returns the cheapest schedule for multiplying by m.
+func BestSchedule(m Matrix) *XORSchedule {
+	b := ExpandBits(m)
+	best := SmartSchedule(b)
+	if cse := CSESchedule(b); cse.XORs() < best.XORs() {
+		best = cse
+	}
+	return best
+}
+
+// errPacketSize is returned if shards cannot be split into 8 packets.
+var errPacketSize = errors.New("shard length must be a non-zero multiple of 8")
+
+// Apply runs the schedule on shards in, each split into 8 packets, and
+// writes out, which must have one shard of the same length per output
+// row group of 8.
+func (s *XORSchedule) Apply(in, out [][]byte) error {
+	if len(in)*8 != s.Inputs || len(out)*8 != s.Outputs {
+		return fmt.Errorf("schedule needs %d inputs and %d outputs", s.Inputs/8, s.Outputs/8)
+	}
+	size := len(in[0])
+	if size == 0 || size%8 != 0 {
+		return errPacketSize
+	}
+	packet := size / 8
+	packets := make([][]byte, s.Inputs+s.Outputs+s.Temps)
+	for i, shard := range append(append([][]byte(nil), in...), out...) {
+		if len(shard) != size {
+			return errPacketSize
+		}
+		for c := 0; c < 8; c++ {
+			packets[8*i+c] = shard[c*packet : (c+1)*packet]
+		}
+	}
+	for _, shard := range out {
+		clear(shard)
+	}
+	if s.Temps > 0 {
+		temps := make([]byte, s.Temps*packet)
+		for t := 0; t < s.Temps; t++ {
+			packets[s.Inputs+s.Outputs+t] = temps[t*packet : (t+1)*packet]
+		}
+	}
+	for _, op := range s.Ops {
+		if op.Copy {
+			copy(packets[op.Dst], packets[op.Src])
+		} else {
+			xorSlice(packets[op.Dst], packets[op.Src])
+		}
+	}
+	return nil
+}
+
+// BitCodec is an erasure codec over any systematic GF(2^8) encoding
+// matrix, e.g. from VandermondeMatrix or CauchyMatrix, that encodes and
+// decodes with XOR schedules of its bit-matrix. Schedules for decoding
+// are built once per erasure pattern and cached.
+type BitCodec struct {
+	dataShards   int
+	parityShards int
+	totalShards  int
+
+	encodingMatrix Matrix
+	encode         *XORSchedule
+	decoders       map[string]*XORSchedule
+
+	DiskArray Matrix
+}
+
+// NewBitCodec returns a codec for the encoding matrix, whose first
+// dataShards rows must be the identity.
+func NewBitCodec(encodingMatrix Matrix, dataShards int) (*BitCodec, error) {
+	err := encodingMatrix.Check()
+	if err != nil {
+		return nil, err
+	}
+	if len(encodingMatrix[0]) != dataShards || len(encodingMatrix) <= dataShards {
+		return nil, errors.New("encoding matrix does not match data shards")
+	}
+	top, _ := encodingMatrix.SubMatrix(0, 0, dataShards, dataShards)
+	identity, _ := IdentityMatrix(dataShards)
+	if top.String() != identity.String() {
+		return nil, errors.New("encoding matrix is not systematic")
+	}
+	parity := encodingMatrix[dataShards:]
+	return &BitCodec{
+		dataShards:     dataShards,
+		parityShards:   len(parity),
+		totalShards:    len(encodingMatrix),
+		encodingMatrix: encodingMatrix,
+		encode:         BestSchedule(parity),
+		decoders:       make(map[string]*XORSchedule),
+	}, nil
+}
+
+// Report returns the schedule costs of the parity rows.
+func (c *BitCodec) Report() ScheduleReport {
+	return ReportSchedule(c.encodingMatrix[c.dataShards:])
+}
+
+// Encode computes the parity shards and stores all shards in DiskArray.
+// Shard lengths must be equal multiples of 8.
+func (c *BitCodec) Encode(shards [][]byte) error {
+	if len(shards) != c.dataShards {
+		return fmt.Errorf("need %d data shards, got %d", c.dataShards, len(shards))
+	}
+	parity, _ := NewMatrix(c.parityShards, max(len(shards[0]), 1))
+	err := c.encode.Apply(shards, parity)
+	if err != nil {
+		return err
+	}
+	c.DiskArray = append(append(Matrix(nil), shards...), parity...)
+	return nil
+}
+
+// ReconstructDisk rebuilds every nil shard of DiskArray from the first
+// dataShards present shards.
+func (c *BitCodec) ReconstructDisk() error {
+	if len(c.DiskArray) != c.totalShards {
+		return errors.New("invalid disk array")
+	}
+	var present, missing []int
+	for i, shard := range c.DiskArray {
+		switch {
+		case shard == nil:
+			missing = append(missing, i)
+		case len(present) < c.dataShards:
+			present = append(present, i)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(present) < c.dataShards {
+		return errors.New("not enough valid disks to reconstruct data")
+	}
+
+	key := fmt.Sprint(present, missing)
+	s, ok := c.decoders[key]
+	if !ok {
+		// missing = encoding[missing] * inverse(encoding[present]) * present
+		sub := make(Matrix, len(present))
+		for j, i := range present {
+			sub[j] = c.encodingMatrix[i]
+		}
+		inverse, err := sub.Invert()
+		if err != nil {
+			return err
+		}
+		rows := make(Matrix, len(missing))
+		for j, i := range missing {
+			rows[j] = c.encodingMatrix[i]
+		}
+		decode, err := rows.Multiply(inverse)
+		if err != nil {
+			return err
+		}
+		s = BestSchedule(decode)
+		c.decoders[key] = s
+	}
+
+	in := make(Matrix, len(present))
+	for j, i := range present {
+		in[j] = c.DiskArray[i]
+	}
+	out, _ := NewMatrix(len(missing), len(in[0]))
+	err := s.Apply(in, out)
+	if err != nil {
+		return err
+	}
+	for j, i := range missing {
+		c.DiskArray[i] = out[j]
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package raid6
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// bitPlanes converts between byte symbols and the bit-plane layout of a
+// shard: packet c holds bit c of every symbol. It returns the symbols of
+// a laid out shard, or lays out a shard of symbols if toPlanes is set.
+func bitPlanes(shard []byte, toPlanes bool) []byte {
+	out := make([]byte, len(shard))
+	packet := len(shard) / 8
+	for sym := 0; sym < len(shard); sym++ {
+		for c := 0; c < 8; c++ {
+			at := c*packet + sym/8
+			if toPlanes {
+				out[at] |= (shard[sym] >> c & 1) << (sym % 8)
+			} else {
+				out[sym] |= (shard[at] >> (sym % 8) & 1) << c
+			}
+		}
+	}
+	return out
+}
+
+// bitEncode multiplies m by the bit-plane shards data in the field.
+func bitEncode(t *testing.T, m Matrix, data [][]byte) Matrix {
+	t.Helper()
+	symbols := make(Matrix, len(data))
+	for i, shard := range data {
+		symbols[i] = bitPlanes(shard, false)
+	}
+	out, err := m.Multiply(symbols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, shard := range out {
+		out[i] = bitPlanes(shard, true)
+	}
+	return out
+}
+
+func TestBitCodecReconstruct(t *testing.T) {
+	for _, g := range []struct{ k, m int }{{4, 2}, {6, 3}, {8, 3}} {
+		for name, build := range map[string]func(int, int) (Matrix, error){
+			"vandermonde": VandermondeMatrix,
+			"cauchy":      CauchyMatrix,
+		} {
+			t.Run(fmt.Sprintf("%s-%d+%d", name, g.k, g.m), func(t *testing.T) {
+				m, err := build(g.k, g.m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c, err := NewBitCodec(m, g.k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rng := rand.New(rand.NewSource(int64(g.k)))
+				data := randomShards(rng, g.k, 64)
+				if err := c.Encode(data); err != nil {
+					t.Fatal(err)
+				}
+				// The XOR schedule must agree with the field arithmetic.
+				want := bitEncode(t, m, data)
+				checkShards(t, c.DiskArray, want)
+
+				for size := 1; size <= g.m; size++ {
+					subsets(g.k+g.m, size, AnalysisOptions{Limit: 2000}, rng, func(lost []int) bool {
+						c.DiskArray = append(Matrix(nil), want...)
+						for _, i := range lost {
+							c.DiskArray[i] = nil
+						}
+						if err := c.ReconstructDisk(); err != nil {
+							t.Fatalf("erasing %v: %v", lost, err)
+						}
+						checkShards(t, c.DiskArray, want)
+						return true
+					})
+				}
+			})
+		}
+	}
+}
+
+func TestXORSchedules(t *testing.T) {
+	m, err := CauchyMatrix(6, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parity := m[6:]
+	b := ExpandBits(parity)
+	data := randomShards(rand.New(rand.NewSource(1)), 6, 128)
+	want := bitEncode(t, parity, data)
+	naive := NaiveSchedule(b)
+	for name, s := range map[string]*XORSchedule{
+		"naive": naive,
+		"smart": SmartSchedule(b),
+		"cse":   CSESchedule(b),
+		"best":  BestSchedule(parity),
+	} {
+		got, _ := NewMatrix(3, 128)
+		if err := s.Apply(data, got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkShards(t, got, want)
+		if s.XORs() > naive.XORs() {
+			t.Errorf("%s schedule needs %d XORs, naive %d", name, s.XORs(), naive.XORs())
+		}
+	}
+}
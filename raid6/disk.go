@@ -0,0 +1,163 @@
+package raid6
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// Disk is a member device of a Volume.
+// *os.File satisfies this interface, so file-backed disks need no wrapper.
+type Disk interface {
+	io.ReaderAt
+	io.WriterAt
+	Sync() error
+	Close() error
+}
+
+// errDiskClosed is returned when accessing a closed in-memory disk.
+var errDiskClosed = errors.New("disk is closed")
+
+// memDisk is an in-memory Disk that grows on write like a sparse file.
+type memDisk struct {
+	mu     sync.Mutex
+	data   []byte
+	closed bool
+}
+
+// NewMemDisk returns an empty in-memory disk.
+func NewMemDisk() Disk {
+	return &memDisk{}
+}
+
+func (d *memDisk) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, errDiskClosed
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *memDisk) WriteAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, errDiskClosed
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	end := off + int64(len(p))
+	if end > int64(len(d.data)) {
+		grown := make([]byte, end)
+		copy(grown, d.data)
+		d.data = grown
+	}
+	return copy(d.data[off:], p), nil
+}
+
+func (d *memDisk) Sync() error {
+	return nil
+}
+
+func (d *memDisk) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	return nil
+}
+
+// OpenFileDisk opens or creates a file-backed disk at path.
+func OpenFileDisk(path string) (Disk, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// region exposes a fixed window [offset, offset+size) of another disk,
+// so a journal can live in a reserved area of an existing device.
+type region struct {
+	disk   Disk
+	offset int64
+	size   int64
+}
+
+// NewRegion returns a Disk backed by size bytes of d starting at offset.
+// Closing the region does not close the underlying disk.
+func NewRegion(d Disk, offset, size int64) Disk {
+	return &region{disk: d, offset: offset, size: size}
+}
+
+func (r *region) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= r.size {
+		return 0, io.EOF
+	}
+	if off+int64(len(p)) > r.size {
+		n, err := r.disk.ReadAt(p[:r.size-off], r.offset+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return r.disk.ReadAt(p, r.offset+off)
+}
+
+func (r *region) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > r.size {
+		return 0, errors.New("write outside of region")
+	}
+	return r.disk.WriteAt(p, r.offset+off)
+}
+
+func (r *region) Sync() error {
+	return r.disk.Sync()
+}
+
+func (r *region) Close() error {
+	return nil
+}
+
+// readFull reads len(p) bytes at off, treating bytes past the end of
+// the disk as zeros. Unwritten chunks of a fresh disk therefore read as
+// an all-zero stripe, which is consistent with all-zero parity.
+func readFull(d Disk, p []byte, off int64) error {
+	n, err := d.ReadAt(p, off)
+	if err == io.EOF {
+		for i := n; i < len(p); i++ {
+			p[i] = 0
+		}
+		return nil
+	}
+	return err
+}
+
+// diskSize returns the number of bytes stored on d.
+func diskSize(d Disk) (int64, error) {
+	switch d := d.(type) {
+	case *os.File:
+		info, err := d.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	case *memDisk:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return int64(len(d.data)), nil
+	case *region:
+		return d.size, nil
+	case interface{ Size() (int64, error) }:
+		return d.Size()
+	}
+	return 0, errors.New("cannot determine disk size")
+}
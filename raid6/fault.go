@@ -0,0 +1,298 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultKind selects the failure a Fault injects.
+type FaultKind int
+
+const (
+	// FaultDiskFailure fails every operation once the fault is active.
+	FaultDiskFailure FaultKind = iota
+	// FaultLatentSector fails reads overlapping [Offset, Offset+Length)
+	// until the range is rewritten, like an unreadable sector that the
+	// drive remaps on the next write.
+	FaultLatentSector
+	// FaultBitRot flips a random bit of each byte read with probability
+	// Rate. The flipped bit is written back, so the rot is persistent.
+	FaultBitRot
+	// FaultTornWrite persists only a random prefix of the next write.
+	FaultTornWrite
+	// FaultMisdirectedWrite stores the next write Shift bytes away from
+	// its target and reports success.
+	FaultMisdirectedWrite
+	// FaultLatency delays reads by Delay, each with probability Rate, or
+	// every read if Rate is zero.
+	FaultLatency
+)
+
+var faultNames = []string{
+	FaultDiskFailure:      "disk-failure",
+	FaultLatentSector:     "latent-sector",
+	FaultBitRot:           "bit-rot",
+	FaultTornWrite:        "torn-write",
+	FaultMisdirectedWrite: "misdirected-write",
+	FaultLatency:          "latency",
+}
+
+func (k FaultKind) String() string {
+	if k < 0 || int(k) >= len(faultNames) {
+		return fmt.Sprintf("fault(%d)", int(k))
+	}
+	return faultNames[k]
+}
+
+// errDiskFailed is returned by every operation on a failed disk.
+var errDiskFailed = errors.New("injected disk failure")
+
+// errSectorRead is returned by reads hitting a latent sector error.
+var errSectorRead = errors.New("injected latent sector error")
+
+// errTornWrite is returned by a write of which only a prefix was persisted.
+var errTornWrite = errors.New("injected torn write")
+
+// Fault is one entry of a FaultSchedule. It becomes active on the At-th
+// operation (read or write) of its disk, counting from zero. Torn and
+// misdirected writes hit a single write and are then used up.
+type Fault struct {
+	Kind   FaultKind
+	At     int
+	Offset int64
+	Length int64
+	Rate   float64
+	Shift  int64
+	Delay  time.Duration
+}
+
+// FaultEvent records an injected fault.
+type FaultEvent struct {
+	Disk   int
+	Op     int
+	Kind   FaultKind
+	Offset int64
+}
+
+// FaultSchedule holds the faults of a set of disks. All randomness (bit
+// rot positions, torn write lengths, latency spikes) comes from one
+// generator seeded at creation, so a run with the same seed and the same
+// sequence of operations injects exactly the same failures.
+type FaultSchedule struct {
+	mu     sync.Mutex
+	rng    *rand.Rand
+	faults map[int][]*Fault
+	events []FaultEvent
+}
+
+// NewFaultSchedule returns an empty schedule seeded with seed.
+func NewFaultSchedule(seed int64) *FaultSchedule {
+	return &FaultSchedule{
+		rng:    rand.New(rand.NewSource(seed)),
+		faults: make(map[int][]*Fault),
+	}
+}
+
+// RandomFaultSchedule returns a schedule of n faults spread over the given
+// number of disks. Faults start within the first ops operations and hit
+// offsets below size. The schedule itself is derived from seed.
+func RandomFaultSchedule(seed int64, disks, n, ops int, size int64) (*FaultSchedule, error) {
+	if disks <= 0 || n < 0 || ops < 0 || size <= 0 {
+		return nil, errors.New("invalid disk count, fault count, operation count or size")
+	}
+	s := NewFaultSchedule(seed)
+	for i := 0; i < n; i++ {
+		f := Fault{
+			Kind:   FaultKind(s.rng.Intn(len(faultNames))),
+			At:     s.rng.Intn(ops + 1),
+			Offset: s.rng.Int63n(size),
+		}
+		switch f.Kind {
+		case FaultLatentSector:
+			f.Length = 512
+		case FaultBitRot:
+			f.Rate = 1e-4
+		case FaultMisdirectedWrite:
+			f.Shift = 4096 * int64(1+s.rng.Intn(4))
+		case FaultLatency:
+			f.Rate = 0.1
+			f.Delay = time.Millisecond
+		}
+		s.Add(s.rng.Intn(disks), f)
+	}
+	return s, nil
+}
+
+// Add schedules fault f on disk.
+func (s *FaultSchedule) Add(disk int, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[disk] = append(s.faults[disk], &f)
+}
+
+// Wrap returns d with the faults scheduled for disk applied to it.
+func (s *FaultSchedule) Wrap(disk int, d Disk) Disk {
+	return &faultDisk{Disk: d, schedule: s, index: disk}
+}
+
+// WrapAll wraps every disk, using its position as the disk number.
+func (s *FaultSchedule) WrapAll(disks []Disk) []Disk {
+	wrapped := make([]Disk, len(disks))
+	for i, d := range disks {
+		wrapped[i] = s.Wrap(i, d)
+	}
+	return wrapped
+}
+
+// Events returns the faults injected so far, in order.
+func (s *FaultSchedule) Events() []FaultEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FaultEvent(nil), s.events...)
+}
+
+func (s *FaultSchedule) record(disk, op int, kind FaultKind, off int64) {
+	s.events = append(s.events, FaultEvent{Disk: disk, Op: op, Kind: kind, Offset: off})
+}
+
+// faultDisk applies the schedule to one disk.
+type faultDisk struct {
+	Disk
+	schedule *FaultSchedule
+	index    int
+	ops      int
+}
+
+// active returns the faults of the disk that are active at operation op.
+func (d *faultDisk) active(op int) []*Fault {
+	var faults []*Fault
+	for _, f := range d.schedule.faults[d.index] {
+		if op >= f.At {
+			faults = append(faults, f)
+		}
+	}
+	return faults
+}
+
+// drop removes a used up fault from the schedule.
+func (d *faultDisk) drop(used *Fault) {
+	faults := d.schedule.faults[d.index]
+	for i, f := range faults {
+		if f == used {
+			d.schedule.faults[d.index] = append(faults[:i:i], faults[i+1:]...)
+			return
+		}
+	}
+}
+
+func overlaps(f *Fault, off, n int64) bool {
+	return off < f.Offset+f.Length && f.Offset < off+n
+}
+
+func (d *faultDisk) ReadAt(p []byte, off int64) (int, error) {
+	s := d.schedule
+	s.mu.Lock()
+	op := d.ops
+	d.ops++
+	var delay time.Duration
+	var rot *Fault
+	for _, f := range d.active(op) {
+		switch f.Kind {
+		case FaultDiskFailure:
+			s.record(d.index, op, f.Kind, off)
+			s.mu.Unlock()
+			return 0, errDiskFailed
+		case FaultLatentSector:
+			if overlaps(f, off, int64(len(p))) {
+				s.record(d.index, op, f.Kind, f.Offset)
+				s.mu.Unlock()
+				return 0, errSectorRead
+			}
+		case FaultBitRot:
+			rot = f
+		case FaultLatency:
+			if f.Rate == 0 || s.rng.Float64() < f.Rate {
+				s.record(d.index, op, f.Kind, off)
+				delay += f.Delay
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+	n, err := d.Disk.ReadAt(p, off)
+	if rot == nil {
+		return n, err
+	}
+
+	// Rot the bytes just read and persist them, so later reads agree.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		if s.rng.Float64() >= rot.Rate {
+			continue
+		}
+		p[i] ^= 1 << s.rng.Intn(8)
+		s.record(d.index, op, FaultBitRot, off+int64(i))
+		_, werr := d.Disk.WriteAt(p[i:i+1], off+int64(i))
+		if werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (d *faultDisk) WriteAt(p []byte, off int64) (int, error) {
+	s := d.schedule
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := d.ops
+	d.ops++
+	for _, f := range d.active(op) {
+		switch f.Kind {
+		case FaultDiskFailure:
+			s.record(d.index, op, f.Kind, off)
+			return 0, errDiskFailed
+		case FaultLatentSector:
+			// The drive remaps the sector on write.
+			if overlaps(f, off, int64(len(p))) {
+				d.drop(f)
+			}
+		case FaultTornWrite:
+			d.drop(f)
+			s.record(d.index, op, f.Kind, off)
+			n, _ := d.Disk.WriteAt(p[:s.rng.Intn(len(p)+1)], off)
+			return n, errTornWrite
+		case FaultMisdirectedWrite:
+			d.drop(f)
+			s.record(d.index, op, f.Kind, off+f.Shift)
+			if off+f.Shift < 0 {
+				return len(p), nil
+			}
+			_, err := d.Disk.WriteAt(p, off+f.Shift)
+			return len(p), err
+		}
+	}
+	return d.Disk.WriteAt(p, off)
+}
+
+func (d *faultDisk) Sync() error {
+	s := d.schedule
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range d.active(d.ops) {
+		if f.Kind == FaultDiskFailure {
+			return errDiskFailed
+		}
+	}
+	return d.Disk.Sync()
+}
+
+// Size reports the size of the underlying disk, so wrapped disks work
+// with StripeCount.
+func (d *faultDisk) Size() (int64, error) {
+	return diskSize(d.Disk)
+}
@@ -0,0 +1,216 @@
+package raid6
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// faultRun drives a volume over disks with the schedule of seed and
+// returns every read result and the injected events.
+func faultRun(t *testing.T, seed int64) ([]string, []FaultEvent) {
+	t.Helper()
+	const chunkSize, stripes = 4096, 8
+	s, err := RandomFaultSchedule(seed, 6, 12, 60, chunkSize*stripes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVolume(4, 2, chunkSize, s.WrapAll(memDisks(6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, v.StripeSize())
+	var outcomes []string
+	for round := 0; round < 3; round++ {
+		for stripe := int64(0); stripe < stripes; stripe++ {
+			for i := range data {
+				data[i] = byte(int(stripe)*7 + round + i)
+			}
+			err := v.WriteStripe(stripe, data)
+			outcomes = append(outcomes, fmt.Sprint("write ", stripe, err))
+		}
+		for stripe := int64(0); stripe < stripes; stripe++ {
+			got, err := v.ReadStripe(stripe)
+			outcomes = append(outcomes, fmt.Sprintf("read %d %v %x", stripe, err, got))
+		}
+	}
+	return outcomes, s.Events()
+}
+
+// TestFaultScheduleReplay checks that a seeded schedule injects the same
+// faults and produces the same outcomes on every run.
+func TestFaultScheduleReplay(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		first, firstEvents := faultRun(t, seed)
+		second, secondEvents := faultRun(t, seed)
+		if len(firstEvents) == 0 {
+			t.Errorf("seed %d injected no faults", seed)
+		}
+		if !reflect.DeepEqual(firstEvents, secondEvents) {
+			t.Fatalf("seed %d: events differ between runs", seed)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("seed %d: outcomes differ between runs", seed)
+		}
+	}
+}
+
+func TestRandomFaultScheduleArguments(t *testing.T) {
+	for _, args := range [][4]int{{0, 1, 1, 1}, {6, -1, 1, 1}, {6, 1, -1, 1}, {6, 1, 1, 0}} {
+		_, err := RandomFaultSchedule(1, args[0], args[1], args[2], int64(args[3]))
+		if err == nil {
+			t.Errorf("RandomFaultSchedule accepted %v", args)
+		}
+	}
+}
+
+// faultDiskOf returns a 4 KiB memory disk of zeros and the same disk
+// wrapped with fault f.
+func faultDiskOf(t *testing.T, f Fault) (raw, d Disk, s *FaultSchedule) {
+	t.Helper()
+	raw = NewMemDisk()
+	if _, err := raw.WriteAt(make([]byte, 4096), 0); err != nil {
+		t.Fatal(err)
+	}
+	s = NewFaultSchedule(1)
+	s.Add(0, f)
+	return raw, s.Wrap(0, raw), s
+}
+
+func TestFaultDiskFailure(t *testing.T) {
+	_, d, s := faultDiskOf(t, Fault{Kind: FaultDiskFailure, At: 2})
+	buf := make([]byte, 16)
+	if _, err := d.WriteAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := d.ReadAt(buf, 0); !errors.Is(err, errDiskFailed) {
+			t.Fatalf("read after failure: %v", err)
+		}
+		if _, err := d.WriteAt(buf, 0); !errors.Is(err, errDiskFailed) {
+			t.Fatalf("write after failure: %v", err)
+		}
+		if err := d.Sync(); !errors.Is(err, errDiskFailed) {
+			t.Fatalf("sync after failure: %v", err)
+		}
+	}
+	if events := s.Events(); len(events) != 6 || events[0].Op != 2 {
+		t.Fatalf("events %v", events)
+	}
+}
+
+func TestFaultLatentSector(t *testing.T) {
+	_, d, _ := faultDiskOf(t, Fault{Kind: FaultLatentSector, Offset: 1024, Length: 512})
+	for _, c := range []struct {
+		off, n int64
+		fail   bool
+	}{
+		{0, 1024, false}, {1536, 512, false}, {1000, 100, true}, {1500, 100, true}, {0, 4096, true},
+	} {
+		_, err := d.ReadAt(make([]byte, c.n), c.off)
+		if c.fail != errors.Is(err, errSectorRead) {
+			t.Fatalf("read of %d bytes at %d: %v", c.n, c.off, err)
+		}
+	}
+	// Rewriting the range remaps the sector.
+	if _, err := d.WriteAt(make([]byte, 512), 1024); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadAt(make([]byte, 4096), 0); err != nil {
+		t.Fatalf("read after rewrite: %v", err)
+	}
+}
+
+func TestFaultTornWrite(t *testing.T) {
+	raw, d, _ := faultDiskOf(t, Fault{Kind: FaultTornWrite})
+	data := bytes.Repeat([]byte{0xff}, 4096)
+	if _, err := d.WriteAt(data, 0); !errors.Is(err, errTornWrite) {
+		t.Fatalf("torn write: %v", err)
+	}
+	got := make([]byte, 4096)
+	if err := readFull(raw, got, 0); err != nil {
+		t.Fatal(err)
+	}
+	prefix := bytes.IndexByte(got, 0)
+	if prefix < 0 {
+		prefix = len(got)
+	}
+	if prefix == len(got) || bytes.Count(got[prefix:], []byte{0}) != len(got)-prefix {
+		t.Fatalf("torn write persisted %d bytes, then not a clean prefix", prefix)
+	}
+
+	// The fault is used up.
+	if _, err := d.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := readFull(raw, got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("write after the torn one not persisted: %v", err)
+	}
+}
+
+func TestFaultMisdirectedWrite(t *testing.T) {
+	raw, d, s := faultDiskOf(t, Fault{Kind: FaultMisdirectedWrite, Shift: 1024})
+	data := []byte("misdirected")
+	if n, err := d.WriteAt(data, 0); n != len(data) || err != nil {
+		t.Fatalf("misdirected write reported %d, %v", n, err)
+	}
+	got := make([]byte, len(data))
+	if err := readFull(raw, got, 1024); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("misdirected write did not land at the shifted offset")
+	}
+	if err := readFull(raw, got, 0); err != nil || !bytes.Equal(got, make([]byte, len(data))) {
+		t.Fatal("misdirected write reached its target")
+	}
+	if events := s.Events(); len(events) != 1 || events[0].Offset != 1024 {
+		t.Fatalf("events %v", events)
+	}
+
+	if _, err := d.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := readFull(raw, got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("write after the misdirected one did not reach its target")
+	}
+}
+
+// TestFaultBitRot checks that about Rate of the bytes read get one bit
+// flipped, and that the rot is written back.
+func TestFaultBitRot(t *testing.T) {
+	const size, rate = 1 << 16, 0.01
+	raw := NewMemDisk()
+	if _, err := raw.WriteAt(make([]byte, size), 0); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFaultSchedule(1)
+	s.Add(0, Fault{Kind: FaultBitRot, Rate: rate})
+	got := make([]byte, size)
+	if _, err := s.Wrap(0, raw).ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	flipped := 0
+	for _, b := range got {
+		if b == 0 {
+			continue
+		}
+		if b&(b-1) != 0 {
+			t.Fatalf("byte %08b has more than one bit flipped", b)
+		}
+		flipped++
+	}
+	// Expect 655 flips; the bounds are more than five sigma away.
+	if flipped < 500 || flipped > 820 {
+		t.Fatalf("%d of %d bytes flipped at rate %g", flipped, size, rate)
+	}
+	if len(s.Events()) != flipped {
+		t.Fatalf("%d events for %d flips", len(s.Events()), flipped)
+	}
+	stored := make([]byte, size)
+	if err := readFull(raw, stored, 0); err != nil || !bytes.Equal(stored, got) {
+		t.Fatal("bit rot not persisted")
+	}
+}
@@ -0,0 +1,392 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"raid6/raid6/gf256"
+)
+
+// The fountain code is a Raptor-style rateless code. The k source blocks
+// are first extended by a dense precode of h parity blocks, every parity
+// a random GF(2^8) combination of all source blocks; together they are
+// the k+h intermediate blocks. Every encoded symbol is an LT symbol: a
+// combination of intermediate blocks with a robust soliton degree and
+// random non-zero coefficients. Neighbours and coefficients are derived
+// from the seed and the symbol id, so a symbol travels as just its id and
+// data and the stream never runs out.
+//
+// The decoder peels: an equation left with one unknown block solves it,
+// and the block is substituted into every other equation. When peeling
+// stalls and there are as many equations as unknown blocks, the precode
+// equations and the remaining symbols are solved together by Gaussian
+// elimination. That is what lets decoding finish a few symbols after k
+// instead of with the tens of percent of overhead plain LT needs, at a
+// cost cubic in the number of blocks peeling left.
+
+// fountainCode holds what encoder and decoder must agree on.
+type fountainCode struct {
+	k, h, n   int
+	blockSize int
+	seed      uint64
+	cdf       []float64
+	precode   Matrix // h x k
+}
+
+// errFountainBlocks is returned if source blocks differ in length.
+var errFountainBlocks = errors.New("source blocks must have the same non-zero length")
+
+func newFountainCode(k, blockSize int, seed int64) (*fountainCode, error) {
+	if k <= 0 || blockSize <= 0 {
+		return nil, errFountainBlocks
+	}
+	err := checkField()
+	if err != nil {
+		return nil, err
+	}
+	// A few dense parities: enough to cover the blocks the LT part leaves
+	// out, few enough that the dense system stays small.
+	h := int(math.Ceil(math.Sqrt(float64(k))/2)) + 2
+	c := &fountainCode{k: k, h: h, n: k + h, blockSize: blockSize, seed: uint64(seed)}
+	c.cdf = robustSoliton(c.n, 0.03, 0.5)
+
+	rng := splitMix(c.seed)
+	c.precode, _ = NewMatrix(h, k)
+	for _, row := range c.precode {
+		for i := range row {
+			row[i] = rng.coefficient()
+		}
+	}
+	return c, nil
+}
+
+// robustSoliton returns the cumulative robust soliton distribution of
+// degrees 1..n with parameters c and delta.
+func robustSoliton(n int, c, delta float64) []float64 {
+	r := c * math.Log(float64(n)/delta) * math.Sqrt(float64(n))
+	spike := int(math.Round(float64(n) / r))
+	p := make([]float64, n+1)
+	total := 0.0
+	for d := 1; d <= n; d++ {
+		if d == 1 {
+			p[d] = 1 / float64(n)
+		} else {
+			p[d] = 1 / float64(d*(d-1))
+		}
+		switch {
+		case d < spike:
+			p[d] += r / float64(d*n)
+		case d == spike:
+			p[d] += r * math.Log(r/delta) / float64(n)
+		}
+		total += p[d]
+	}
+	cdf := make([]float64, n+1)
+	for d := 1; d <= n; d++ {
+		cdf[d] = cdf[d-1] + p[d]/total
+	}
+	return cdf
+}
+
+// rng is a splitmix64 generator. It is small and its output is fixed,
+// which matters because encoder and decoder must draw the same values.
+type rng uint64
+
+func splitMix(seed uint64) *rng {
+	r := rng(seed)
+	return &r
+}
+
+func (r *rng) next() uint64 {
+	*r += 0x9e3779b97f4a7c15
+	z := uint64(*r)
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+func (r *rng) float() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+func (r *rng) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+func (r *rng) coefficient() byte {
+	return byte(1 + r.intn(255))
+}
+
+// combination returns the intermediate blocks and coefficients of
+// symbol id.
+func (c *fountainCode) combination(id uint32) ([]int, []byte) {
+	r := splitMix(c.seed ^ uint64(id)*0xd1342543de82ef95)
+	u := r.float()
+	degree := 1
+	for degree < c.n && c.cdf[degree] < u {
+		degree++
+	}
+	picked := make(map[int]bool, degree)
+	blocks := make([]int, 0, degree)
+	coefs := make([]byte, 0, degree)
+	for len(blocks) < degree {
+		b := r.intn(c.n)
+		if !picked[b] {
+			picked[b] = true
+			blocks = append(blocks, b)
+			coefs = append(coefs, r.coefficient())
+		}
+	}
+	return blocks, coefs
+}
+
+// FountainSymbol is one encoded symbol of the stream.
+type FountainSymbol struct {
+	ID   uint32
+	Data []byte
+}
+
+// FountainEncoder generates an unbounded stream of symbols from source
+// blocks.
+type FountainEncoder struct {
+	code         *fountainCode
+	intermediate Matrix
+	next         uint32
+}
+
+// NewFountainEncoder returns an encoder for the source blocks. Receivers
+// need the number of blocks, the block size and the seed.
+func NewFountainEncoder(source [][]byte, seed int64) (*FountainEncoder, error) {
+	if len(source) == 0 {
+		return nil, errFountainBlocks
+	}
+	for _, block := range source {
+		if len(block) != len(source[0]) {
+			return nil, errFountainBlocks
+		}
+	}
+	code, err := newFountainCode(len(source), len(source[0]), seed)
+	if err != nil {
+		return nil, err
+	}
+	e := &FountainEncoder{code: code, intermediate: append(Matrix(nil), source...)}
+	for _, row := range code.precode {
+		parity := make([]byte, code.blockSize)
+		for i, coef := range row {
+			gf256.MulAddSlice(coef, source[i], parity)
+		}
+		e.intermediate = append(e.intermediate, parity)
+	}
+	return e, nil
+}
+
+// Symbol returns the symbol with the given id.
+func (e *FountainEncoder) Symbol(id uint32) FountainSymbol {
+	blocks, coefs := e.code.combination(id)
+	data := make([]byte, e.code.blockSize)
+	for j, b := range blocks {
+		gf256.MulAddSlice(coefs[j], e.intermediate[b], data)
+	}
+	return FountainSymbol{ID: id, Data: data}
+}
+
+// Next returns the next symbol of the stream.
+func (e *FountainEncoder) Next() FountainSymbol {
+	s := e.Symbol(e.next)
+	e.next++
+	return s
+}
+
+// fountainEquation is the sum of coefs[b] times unknown block b, over the
+// blocks not yet solved, equal to data.
+type fountainEquation struct {
+	coefs map[int]byte
+	data  []byte
+}
+
+// FountainDecoder recovers the source blocks from symbols in any order.
+type FountainDecoder struct {
+	code      *fountainCode
+	equations []*fountainEquation
+	uses      [][]int // equations per intermediate block
+	solved    Matrix
+	remaining int
+	received  int
+	seen      map[uint32]bool
+}
+
+// errFountainSymbol is returned for a symbol of the wrong size.
+var errFountainSymbol = errors.New("fountain symbol has the wrong size")
+
+// NewFountainDecoder returns a decoder for k source blocks of blockSize
+// bytes encoded with seed.
+func NewFountainDecoder(k, blockSize int, seed int64) (*FountainDecoder, error) {
+	code, err := newFountainCode(k, blockSize, seed)
+	if err != nil {
+		return nil, err
+	}
+	d := &FountainDecoder{
+		code:      code,
+		uses:      make([][]int, code.n),
+		solved:    make(Matrix, code.n),
+		remaining: code.n,
+		seen:      make(map[uint32]bool),
+	}
+	// The precode: parity j minus its combination of source blocks is 0.
+	for j, row := range code.precode {
+		eq := &fountainEquation{coefs: map[int]byte{k + j: 1}, data: make([]byte, blockSize)}
+		for i, coef := range row {
+			eq.coefs[i] = coef
+		}
+		d.addEquation(eq)
+	}
+	return d, nil
+}
+
+// Received returns the number of distinct symbols added.
+func (d *FountainDecoder) Received() int {
+	return d.received
+}
+
+// Done reports whether every source block is known.
+func (d *FountainDecoder) Done() bool {
+	for _, block := range d.solved[:d.code.k] {
+		if block == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Add adds a symbol and reports whether decoding is complete. Duplicate
+// symbols are ignored.
+func (d *FountainDecoder) Add(s FountainSymbol) (bool, error) {
+	if len(s.Data) != d.code.blockSize {
+		return false, errFountainSymbol
+	}
+	if d.Done() || d.seen[s.ID] {
+		return d.Done(), nil
+	}
+	d.seen[s.ID] = true
+	d.received++
+
+	blocks, coefs := d.code.combination(s.ID)
+	eq := &fountainEquation{coefs: make(map[int]byte, len(blocks)), data: append([]byte(nil), s.Data...)}
+	for j, b := range blocks {
+		if d.solved[b] != nil {
+			gf256.MulAddSlice(coefs[j], d.solved[b], eq.data)
+		} else {
+			eq.coefs[b] = coefs[j]
+		}
+	}
+	d.addEquation(eq)
+
+	if !d.Done() && d.pending() >= d.remaining {
+		d.eliminate()
+	}
+	return d.Done(), nil
+}
+
+// addEquation records eq and peels as far as it goes.
+func (d *FountainDecoder) addEquation(eq *fountainEquation) {
+	if len(eq.coefs) == 0 {
+		return
+	}
+	index := len(d.equations)
+	d.equations = append(d.equations, eq)
+	for b := range eq.coefs {
+		d.uses[b] = append(d.uses[b], index)
+	}
+	queue := []int{index}
+	for len(queue) > 0 {
+		eq := d.equations[queue[0]]
+		queue = queue[1:]
+		if len(eq.coefs) != 1 {
+			continue
+		}
+		for b, coef := range eq.coefs {
+			gf256.MulSlice(gf256.Inv(coef), eq.data, eq.data)
+			queue = append(queue, d.solve(b, eq.data)...)
+		}
+	}
+}
+
+// solve records block b and substitutes it into every equation using
+// it, returning the equations left with one unknown.
+func (d *FountainDecoder) solve(b int, value []byte) []int {
+	d.solved[b] = value
+	d.remaining--
+	var single []int
+	for _, i := range d.uses[b] {
+		eq := d.equations[i]
+		coef, ok := eq.coefs[b]
+		if !ok {
+			continue
+		}
+		delete(eq.coefs, b)
+		if len(eq.coefs) > 0 {
+			gf256.MulAddSlice(coef, value, eq.data)
+		}
+		if len(eq.coefs) == 1 {
+			single = append(single, i)
+		}
+	}
+	d.uses[b] = nil
+	return single
+}
+
+// pending returns the number of equations with unknowns left.
+func (d *FountainDecoder) pending() int {
+	n := 0
+	for _, eq := range d.equations {
+		if len(eq.coefs) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// eliminate solves the blocks peeling could not from all pending
+// equations at once. Too few independent equations leave the decoder
+// waiting for more symbols.
+func (d *FountainDecoder) eliminate() {
+	var unknown []int
+	column := make(map[int]int)
+	for b, block := range d.solved {
+		if block == nil {
+			column[b] = len(unknown)
+			unknown = append(unknown, b)
+		}
+	}
+	var pending []*fountainEquation
+	for _, eq := range d.equations {
+		if len(eq.coefs) > 0 {
+			pending = append(pending, eq)
+		}
+	}
+
+	system, _ := NewMatrix(len(pending), len(unknown))
+	data := make(Matrix, len(pending))
+	for i, eq := range pending {
+		for b, coef := range eq.coefs {
+			system[i][column[b]] = coef
+		}
+		data[i] = eq.data
+	}
+	solution, err := SolveBlocks(system, data)
+	if err != nil {
+		return
+	}
+	for r, b := range unknown {
+		d.solve(b, solution[r])
+	}
+}
+
+// Source returns the decoded source blocks.
+func (d *FountainDecoder) Source() ([][]byte, error) {
+	if !d.Done() {
+		return nil, fmt.Errorf("fountain decoder needs more symbols, %d received", d.received)
+	}
+	return d.solved[:d.code.k], nil
+}
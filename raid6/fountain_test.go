@@ -0,0 +1,80 @@
+package raid6
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestFountainDecode(t *testing.T) {
+	for _, k := range []int{1, 10, 100} {
+		t.Run(fmt.Sprint(k), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(k)))
+			source := randomShards(rng, k, 32)
+			e, err := NewFountainEncoder(source, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewFountainDecoder(k, 32, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Lose a third of the stream, repeat some symbols and stop as
+			// soon as the decoder reports completion.
+			done := false
+			for sent := 0; !done; sent++ {
+				if sent > 2*k+20 {
+					t.Fatalf("not decoded after %d symbols, %d received", sent, d.Received())
+				}
+				s := e.Next()
+				if rng.Intn(3) == 0 {
+					continue
+				}
+				for repeat := 0; repeat <= rng.Intn(2); repeat++ {
+					done, err = d.Add(s)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			got, err := d.Source()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkShards(t, got, source)
+		})
+	}
+}
+
+func TestFountainOutOfOrder(t *testing.T) {
+	const k = 40
+	rng := rand.New(rand.NewSource(1))
+	source := randomShards(rng, k, 16)
+	e, err := NewFountainEncoder(source, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewFountainDecoder(k, 16, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Source(); err == nil {
+		t.Fatal("source returned before any symbol")
+	}
+	if _, err := d.Add(FountainSymbol{Data: make([]byte, 15)}); err == nil {
+		t.Fatal("short symbol accepted")
+	}
+	for _, id := range rng.Perm(2 * k) {
+		if done, err := d.Add(e.Symbol(uint32(id) + 1000)); err != nil {
+			t.Fatal(err)
+		} else if done {
+			break
+		}
+	}
+	got, err := d.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShards(t, got, source)
+}
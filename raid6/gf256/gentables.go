@@ -0,0 +1,141 @@
+//go:build ignore
+
+// gentables writes tables.go with every lookup table of the field,
+// derived from the generating polynomial:
+//
+//	go run gentables.go -poly 29 -o tables.go
+//
+// Any primitive polynomial of degree 8 may be given by its low 8 bits.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+)
+
+func main() {
+	poly := flag.Int("poly", 29, "generating polynomial without the x^8 term")
+	out := flag.String("o", "tables.go", "output file")
+	flag.Parse()
+	if *poly <= 0 || *poly > 255 {
+		log.Fatalf("polynomial %d out of range", *poly)
+	}
+
+	// Powers of the generator x, reduced by the polynomial.
+	var exp [256]byte
+	var logs [256]byte
+	seen := make(map[byte]bool)
+	x := 1
+	for i := 0; i < 255; i++ {
+		if seen[byte(x)] {
+			log.Fatalf("polynomial %d is not primitive", *poly)
+		}
+		seen[byte(x)] = true
+		exp[i] = byte(x)
+		logs[x] = byte(i)
+		x <<= 1
+		if x >= 256 {
+			x = (x - 256) ^ *poly
+		}
+	}
+	exp[255] = exp[0]
+
+	mul := func(a, b byte) byte {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[(int(logs[a])+int(logs[b]))%255]
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `/**
+ * 8-bit Galois Field
+ * Copyright 2015, Klaus Post
+ * Copyright 2015, Backblaze, Inc.  All rights reserved.
+ */
+
+// Code generated by gentables.go -poly %d; DO NOT EDIT.
+
+package gf256
+
+// Polynomial is the generating polynomial of the field without the
+// x^8 term. The tables below were derived from it.
+//
+// The primitive polynomials of degree 8 are: 29, 43, 45, 77, 95, 99,
+// 101, 105, 113, 135, 141, 169, 195, 207, 231, and 245.
+const Polynomial = %d
+
+// logTable maps a non-zero element to its logarithm to the base 2.
+// Entry 0 is unused.
+`, *poly, *poly)
+	writeRow(&buf, "var logTable = [256]byte", logs[:])
+	buf.WriteString("\n// expTable maps a logarithm to its element. Entry 255 is the same as\n// entry 0, so lookups of a sum of two logarithms modulo 255 need no check.\n")
+	writeRow(&buf, "var expTable = [256]byte", exp[:])
+
+	var inv [256]byte
+	for a := 1; a < 256; a++ {
+		inv[a] = exp[(255-int(logs[a]))%255]
+	}
+	buf.WriteString("\n// invTable maps an element to its multiplicative inverse. Entry 0 is unused.\n")
+	writeRow(&buf, "var invTable = [256]byte", inv[:])
+
+	buf.WriteString("\n// mulTable holds the product of every pair of elements.\nvar mulTable = [256][256]byte{\n")
+	for a := 0; a < 256; a++ {
+		row := make([]byte, 256)
+		for b := range row {
+			row[b] = mul(byte(a), byte(b))
+		}
+		writeRow(&buf, "", row)
+	}
+	buf.WriteString("}\n")
+
+	// Nibble tables: c*x = mulTableLow[c][x&15] ^ mulTableHigh[c][x>>4].
+	buf.WriteString("\n// mulTableLow holds the products with the low nibble of a byte.\nvar mulTableLow = [256][16]byte{\n")
+	for c := 0; c < 256; c++ {
+		row := make([]byte, 16)
+		for i := range row {
+			row[i] = mul(byte(c), byte(i))
+		}
+		writeRow(&buf, "", row)
+	}
+	buf.WriteString("}\n\n// mulTableHigh holds the products with the high nibble of a byte.\nvar mulTableHigh = [256][16]byte{\n")
+	for c := 0; c < 256; c++ {
+		row := make([]byte, 16)
+		for i := range row {
+			row[i] = mul(byte(c), byte(i<<4))
+		}
+		writeRow(&buf, "", row)
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.WriteFile(*out, src, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeRow writes a composite literal of values, prefixed by decl if it
+// is not empty and followed by a comma otherwise.
+func writeRow(buf *bytes.Buffer, decl string, values []byte) {
+	buf.WriteString(decl)
+	buf.WriteString("{")
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(buf, "0x%02x", v)
+	}
+	buf.WriteString("}")
+	if decl == "" {
+		buf.WriteString(",")
+	}
+	buf.WriteString("\n")
+}
@@ -0,0 +1,92 @@
+/**
+ * 8-bit Galois Field
+ * Copyright 2015, Klaus Post
+ * Copyright 2015, Backblaze, Inc.  All rights reserved.
+ */
+
+// Package gf256 implements arithmetic in GF(2^8), the field the raid6
+// codec computes in. Elements are bytes; addition is XOR and
+// multiplication is modulo the polynomial x^8 + Polynomial, using the
+// same tables as the codec, so results match its parity exactly.
+//
+// Like integer division, Div, Inv and Log panic when given zero where
+// the field has no answer; callers check the divisor first. Operations
+// on polynomials return errors instead.
+package gf256
+
+import "errors"
+
+//go:generate go run gentables.go -poly 29 -o tables.go
+
+// Order is the number of elements in the field.
+const Order = 256
+
+// errDivideByZero is the panic value of a division by zero.
+var errDivideByZero = errors.New("gf256: division by zero")
+
+// Add returns a + b. Subtraction is the same operation.
+func Add(a, b byte) byte {
+	return a ^ b
+}
+
+// Sub returns a - b, which equals a + b in characteristic 2.
+func Sub(a, b byte) byte {
+	return a ^ b
+}
+
+// Mul returns a * b.
+func Mul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+// Div returns a / b. It panics if b is zero.
+func Div(a, b byte) byte {
+	if b == 0 {
+		panic(errDivideByZero)
+	}
+	if a == 0 {
+		return 0
+	}
+	logResult := int(logTable[a]) - int(logTable[b])
+	if logResult < 0 {
+		logResult += 255
+	}
+	return expTable[logResult]
+}
+
+// Inv returns the multiplicative inverse of a. It panics if a is zero.
+func Inv(a byte) byte {
+	if a == 0 {
+		panic(errDivideByZero)
+	}
+	return invTable[a]
+}
+
+// Exp returns the generator 2 raised to the power n, for any n.
+func Exp(n int) byte {
+	n %= 255
+	if n < 0 {
+		n += 255
+	}
+	return expTable[n]
+}
+
+// Log returns the discrete logarithm of a to the base 2, in [0, 254],
+// so that Exp(Log(a)) == a. It panics if a is zero.
+func Log(a byte) int {
+	if a == 0 {
+		panic(errors.New("gf256: logarithm of zero"))
+	}
+	return int(logTable[a])
+}
+
+// Pow returns a raised to the power n >= 0.
+func Pow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return Exp(int(logTable[a]) * (n % 255))
+}
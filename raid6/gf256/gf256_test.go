@@ -0,0 +1,152 @@
+package gf256
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// slowMul multiplies by shifting and reducing, without the tables.
+func slowMul(a, b byte) byte {
+	var p byte
+	for ; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= Polynomial
+		}
+	}
+	return p
+}
+
+func TestArithmetic(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		got  byte
+		want byte
+	}{
+		{"Mul(0, 7)", Mul(0, 7), 0},
+		{"Mul(1, 7)", Mul(1, 7), 7},
+		{"Mul(3, 7)", Mul(3, 7), 9},
+		{"Mul(2, 0x80)", Mul(2, 0x80), 0x1d},
+		{"Div(9, 7)", Div(9, 7), 3},
+		{"Div(0, 5)", Div(0, 5), 0},
+		{"Div(1, 2)", Div(1, 2), 0x8e},
+		{"Inv(1)", Inv(1), 1},
+		{"Inv(2)", Inv(2), 0x8e},
+		{"Pow(2, 8)", Pow(2, 8), 0x1d},
+		{"Pow(2, 255)", Pow(2, 255), 1},
+		{"Pow(0, 0)", Pow(0, 0), 1},
+		{"Pow(0, 5)", Pow(0, 5), 0},
+		{"Pow(3, 1)", Pow(3, 1), 3},
+		{"Exp(-1)", Exp(-1), 0x8e},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %#x, want %#x", c.name, c.got, c.want)
+		}
+	}
+}
+
+// TestArithmeticExhaustive checks the tables against slowMul and the
+// inverse operations against each other for every pair of elements.
+func TestArithmeticExhaustive(t *testing.T) {
+	for a := 0; a < Order; a++ {
+		for b := 0; b < Order; b++ {
+			x, y := byte(a), byte(b)
+			if Mul(x, y) != slowMul(x, y) {
+				t.Fatalf("Mul(%#x, %#x) = %#x, want %#x", x, y, Mul(x, y), slowMul(x, y))
+			}
+			if y != 0 && Div(Mul(x, y), y) != x {
+				t.Fatalf("Div(Mul(%#x, %#x), %#x) != %#x", x, y, y, x)
+			}
+		}
+		x := byte(a)
+		if x != 0 && Mul(x, Inv(x)) != 1 {
+			t.Fatalf("%#x * Inv(%#x) != 1", x, x)
+		}
+		if x != 0 && Exp(Log(x)) != x {
+			t.Fatalf("Exp(Log(%#x)) != %#x", x, x)
+		}
+		want := byte(1)
+		for n := 0; n < 300; n++ {
+			if got := Pow(x, n); got != want {
+				t.Fatalf("Pow(%#x, %d) = %#x, want %#x", x, n, got, want)
+			}
+			want = Mul(want, x)
+		}
+	}
+}
+
+func TestDivideByZero(t *testing.T) {
+	for name, f := range map[string]func(){
+		"Div": func() { Div(1, 0) },
+		"Inv": func() { Inv(0) },
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, errDivideByZero) {
+					t.Errorf("%s by zero panicked with %v", name, err)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func randomPoly(rng *rand.Rand, degree int) Poly {
+	p := make(Poly, degree+1)
+	rng.Read(p)
+	p[degree] |= 1
+	return p
+}
+
+func TestPolyDivMod(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		p := randomPoly(rng, rng.Intn(20))
+		d := randomPoly(rng, rng.Intn(8))
+		q, r, err := p.DivMod(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Degree() >= d.Degree() {
+			t.Fatalf("remainder of degree %d for divisor of degree %d", r.Degree(), d.Degree())
+		}
+		if back := q.Mul(d).Add(r); !bytes.Equal(back.trim(), p.trim()) {
+			t.Fatalf("q*d + r = %v, want %v", back, p)
+		}
+	}
+	if _, _, err := (Poly{1, 2}).DivMod(Poly{0, 0}); !errors.Is(err, errZeroDivisor) {
+		t.Fatalf("division by the zero polynomial: %v", err)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 1; n <= 20; n++ {
+		p := randomPoly(rng, n-1)
+		xs := make([]byte, n)
+		ys := make([]byte, n)
+		for i, x := range rng.Perm(Order)[:n] {
+			xs[i] = byte(x)
+			ys[i] = p.Eval(xs[i])
+		}
+		got, err := Interpolate(xs, ys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.trim(), p.trim()) {
+			t.Fatalf("interpolated %v through %d points of %v", got, n, p)
+		}
+	}
+	if _, err := Interpolate([]byte{1, 1}, []byte{2, 3}); !errors.Is(err, errDuplicatePoint) {
+		t.Fatalf("duplicate point: %v", err)
+	}
+	if _, err := Interpolate([]byte{1}, nil); err == nil {
+		t.Fatal("Interpolate accepted xs and ys of different lengths")
+	}
+}
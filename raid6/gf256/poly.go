@@ -0,0 +1,130 @@
+package gf256
+
+import "errors"
+
+// Poly is a polynomial over GF(2^8) with the coefficient of x^i at index i.
+// Trailing zero coefficients are allowed; the zero polynomial may be nil.
+type Poly []byte
+
+// errZeroDivisor is returned when dividing by the zero polynomial.
+var errZeroDivisor = errors.New("gf256: division by the zero polynomial")
+
+// errDuplicatePoint is returned by Interpolate if two points share an x.
+var errDuplicatePoint = errors.New("gf256: interpolation points must have distinct x")
+
+// Degree returns the degree of p, or -1 for the zero polynomial.
+func (p Poly) Degree() int {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i] != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// trim drops trailing zero coefficients.
+func (p Poly) trim() Poly {
+	return p[:p.Degree()+1]
+}
+
+// Eval returns p(x), using Horner's rule.
+func (p Poly) Eval(x byte) byte {
+	var y byte
+	for i := len(p) - 1; i >= 0; i-- {
+		y = mulTable[y][x] ^ p[i]
+	}
+	return y
+}
+
+// Add returns p + q.
+func (p Poly) Add(q Poly) Poly {
+	if len(q) > len(p) {
+		p, q = q, p
+	}
+	sum := append(Poly(nil), p...)
+	for i, c := range q {
+		sum[i] ^= c
+	}
+	return sum.trim()
+}
+
+// Scale returns c * p.
+func (p Poly) Scale(c byte) Poly {
+	scaled := make(Poly, len(p))
+	MulSlice(c, p, scaled)
+	return scaled.trim()
+}
+
+// Mul returns p * q.
+func (p Poly) Mul(q Poly) Poly {
+	p, q = p.trim(), q.trim()
+	if len(p) == 0 || len(q) == 0 {
+		return nil
+	}
+	product := make(Poly, len(p)+len(q)-1)
+	for i, c := range p {
+		MulAddSlice(c, q, product[i:])
+	}
+	return product
+}
+
+// DivMod returns the quotient and remainder of p / d, so that
+// p = q*d + r with deg r < deg d.
+func (p Poly) DivMod(d Poly) (q, r Poly, err error) {
+	d = d.trim()
+	if len(d) == 0 {
+		return nil, nil, errZeroDivisor
+	}
+	r = append(Poly(nil), p.trim()...)
+	if len(r) < len(d) {
+		return nil, r, nil
+	}
+
+	lead := Inv(d[len(d)-1])
+	q = make(Poly, len(r)-len(d)+1)
+	for i := len(q) - 1; i >= 0; i-- {
+		c := mulTable[r[i+len(d)-1]][lead]
+		q[i] = c
+		MulAddSlice(c, d, r[i:])
+	}
+	return q.trim(), r.trim(), nil
+}
+
+// Derivative returns the formal derivative of p. In characteristic 2 the
+// terms of even degree vanish.
+func (p Poly) Derivative() Poly {
+	if len(p) < 2 {
+		return nil
+	}
+	d := make(Poly, len(p)-1)
+	for i := 1; i < len(p); i += 2 {
+		d[i-1] = p[i]
+	}
+	return d.trim()
+}
+
+// Interpolate returns the polynomial of degree below len(xs) through the
+// points (xs[i], ys[i]), built from the Lagrange basis polynomials.
+func Interpolate(xs, ys []byte) (Poly, error) {
+	if len(xs) != len(ys) {
+		return nil, errors.New("gf256: xs and ys differ in length")
+	}
+	var p Poly
+	for i, xi := range xs {
+		// basis is 1 at xi and 0 at every other point.
+		basis := Poly{1}
+		denom := byte(1)
+		for j, xj := range xs {
+			if j == i {
+				continue
+			}
+			if xj == xi {
+				return nil, errDuplicatePoint
+			}
+			basis = basis.Mul(Poly{xj, 1})
+			denom = mulTable[denom][xi^xj]
+		}
+		p = p.Add(basis.Scale(Div(ys[i], denom)))
+	}
+	return p, nil
+}
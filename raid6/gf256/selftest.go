@@ -0,0 +1,52 @@
+package gf256
+
+import "fmt"
+
+// SelfTest cross-checks the lookup tables against each other and against
+// Polynomial: the exponent table must step through every non-zero
+// element by multiplying with x modulo the polynomial, logarithms must
+// invert it, and the product, inverse and nibble tables must agree with
+// products computed from logarithms. It returns the first inconsistency.
+func SelfTest() error {
+	x := 1
+	for i := 0; i < 255; i++ {
+		if expTable[i] != byte(x) {
+			return fmt.Errorf("gf256: expTable[%d] is %#x, want %#x", i, expTable[i], x)
+		}
+		if i > 0 && x == 1 {
+			return fmt.Errorf("gf256: polynomial %d is not primitive", Polynomial)
+		}
+		if logTable[x] != byte(i) {
+			return fmt.Errorf("gf256: logTable[%#x] is %d, want %d", x, logTable[x], i)
+		}
+		x <<= 1
+		if x >= 256 {
+			x = (x - 256) ^ Polynomial
+		}
+	}
+	if expTable[255] != expTable[0] {
+		return fmt.Errorf("gf256: expTable[255] differs from expTable[0]")
+	}
+
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			want := byte(0)
+			if a != 0 && b != 0 {
+				want = expTable[(int(logTable[a])+int(logTable[b]))%255]
+			}
+			if mulTable[a][b] != want {
+				return fmt.Errorf("gf256: mulTable[%#x][%#x] is %#x, want %#x", a, b, mulTable[a][b], want)
+			}
+			if mulTable[a][b] != mulTable[b][a] {
+				return fmt.Errorf("gf256: mulTable is not symmetric at %#x, %#x", a, b)
+			}
+			if nibbles := mulTableLow[a][b&15] ^ mulTableHigh[a][b>>4]; nibbles != want {
+				return fmt.Errorf("gf256: nibble tables give %#x * %#x = %#x, want %#x", a, b, nibbles, want)
+			}
+		}
+		if a != 0 && mulTable[a][invTable[a]] != 1 {
+			return fmt.Errorf("gf256: invTable[%#x] is %#x, not an inverse", a, invTable[a])
+		}
+	}
+	return nil
+}
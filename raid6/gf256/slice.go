@@ -0,0 +1,38 @@
+package gf256
+
+// MulSlice sets out[i] = c * in[i]. out must be at least as long as in.
+func MulSlice(c byte, in, out []byte) {
+	mt := &mulTable[c]
+	out = out[:len(in)]
+	for i, v := range in {
+		out[i] = mt[v]
+	}
+}
+
+// MulAddSlice sets out[i] += c * in[i], the step used to accumulate
+// one data shard into a parity shard. out must be at least as long as in.
+func MulAddSlice(c byte, in, out []byte) {
+	mt := &mulTable[c]
+	out = out[:len(in)]
+	for i, v := range in {
+		out[i] ^= mt[v]
+	}
+}
+
+// AddSlice sets out[i] += in[i]. out must be at least as long as in.
+func AddSlice(in, out []byte) {
+	out = out[:len(in)]
+	for i, v := range in {
+		out[i] ^= v
+	}
+}
+
+// Dot returns the inner product of a and b, which must have equal length.
+func Dot(a, b []byte) byte {
+	b = b[:len(a)]
+	var sum byte
+	for i, v := range a {
+		sum ^= mulTable[v][b[i]]
+	}
+	return sum
+}
@@ -0,0 +1,30 @@
+package raid6
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// randomShards returns n shards of size random bytes.
+func randomShards(rng *rand.Rand, n, size int) [][]byte {
+	shards := make([][]byte, n)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		rng.Read(shards[i])
+	}
+	return shards
+}
+
+// checkShards fails unless got holds the same shards as want.
+func checkShards(t *testing.T, got, want [][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d shards, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("shard %d differs", i)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package raid6
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// The journal is a log of full stripe updates. Every stripe write is
+// first appended to the journal and synced, then written to the member
+// disks, and finally marked as applied. A crash between writing a data
+// chunk and its parity therefore leaves a pending record behind, and
+// replaying it rewrites the whole stripe so data and parity agree again.
+//
+// Record layout (little endian):
+//
+//	[magic 4][state 1][pad 3][seq 8][stripe 8][size 4][crc 4][payload size]
+//
+// The checksum covers seq, stripe, size and payload, but not the state
+// byte, so a record can be marked applied without rewriting it. Records
+// of stripes still in the old geometry of a reshape carry
+// journalOldGeometry in the stripe field.
+
+const (
+	journalMagic      = 0x4a364452 // "RD6J"
+	journalHeaderSize = 32
+
+	recordPending = 1
+	recordApplied = 2
+
+	// journalOldGeometry flags a record written in the old geometry.
+	journalOldGeometry = 1 << 62
+)
+
+// errJournalFull is returned if a single record does not fit in the journal.
+var errJournalFull = errors.New("stripe record larger than journal")
+
+// Journal is a write-ahead log of stripe updates for a Volume.
+type Journal struct {
+	disk Disk
+	// size is the capacity of the journal in bytes. Zero means the
+	// journal is a dedicated file, which starts over at offset 0 once
+	// its last record is applied and so never outgrows one record.
+	size int64
+	head int64
+	seq  uint64
+}
+
+type journalRecord struct {
+	offset  int64
+	seq     uint64
+	stripe  int64
+	state   byte
+	payload []byte
+}
+
+// OpenJournal opens the journal stored on d. If size is positive the
+// journal is used as a ring of that many bytes, which allows it to live
+// in a reserved region of a disk. The existing log is scanned so that
+// new records continue after the last valid one.
+func OpenJournal(d Disk, size int64) (*Journal, error) {
+	if size < 0 {
+		return nil, errors.New("invalid journal size")
+	}
+	j := &Journal{disk: d, size: size}
+	records, err := j.scan()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		j.head = last.offset + journalHeaderSize + int64(len(last.payload))
+		j.seq = last.seq
+	}
+	return j, nil
+}
+
+// scan walks the log from the start and returns the chain of valid
+// records with increasing sequence numbers. It stops at the first torn
+// or stale record, which marks the point the next append will overwrite.
+func (j *Journal) scan() ([]journalRecord, error) {
+	var records []journalRecord
+	var offset int64
+	var lastSeq uint64
+	for {
+		rec, ok, err := j.readRecord(offset)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || rec.seq <= lastSeq {
+			return records, nil
+		}
+		records = append(records, rec)
+		lastSeq = rec.seq
+		offset += journalHeaderSize + int64(len(rec.payload))
+	}
+}
+
+func (j *Journal) readRecord(offset int64) (journalRecord, bool, error) {
+	header := make([]byte, journalHeaderSize)
+	if j.size > 0 && offset+journalHeaderSize > j.size {
+		return journalRecord{}, false, nil
+	}
+	err := readFull(j.disk, header, offset)
+	if err != nil {
+		return journalRecord{}, false, err
+	}
+	if binary.LittleEndian.Uint32(header[0:4]) != journalMagic {
+		return journalRecord{}, false, nil
+	}
+
+	size := int64(binary.LittleEndian.Uint32(header[24:28]))
+	if j.size > 0 && offset+journalHeaderSize+size > j.size {
+		return journalRecord{}, false, nil
+	}
+	payload := make([]byte, size)
+	err = readFull(j.disk, payload, offset+journalHeaderSize)
+	if err != nil {
+		return journalRecord{}, false, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:28])
+	crc.Write(payload)
+	if crc.Sum32() != binary.LittleEndian.Uint32(header[28:32]) {
+		// Torn write: the record never made it to stable storage.
+		return journalRecord{}, false, nil
+	}
+
+	rec := journalRecord{
+		offset:  offset,
+		state:   header[4],
+		seq:     binary.LittleEndian.Uint64(header[8:16]),
+		stripe:  int64(binary.LittleEndian.Uint64(header[16:24])),
+		payload: payload,
+	}
+	return rec, true, nil
+}
+
+// append writes a pending record for the given stripe and syncs it.
+// It returns the record offset to pass to complete.
+func (j *Journal) append(stripe int64, shards [][]byte) (int64, error) {
+	var size int64
+	for _, shard := range shards {
+		size += int64(len(shard))
+	}
+	recordSize := journalHeaderSize + size
+	if j.size > 0 && recordSize > j.size {
+		return 0, errJournalFull
+	}
+	if j.size > 0 && j.head+recordSize > j.size {
+		// Wrap around. Every earlier record has already been applied
+		// because stripe writes are serialized by the volume.
+		j.head = 0
+	}
+
+	buf := make([]byte, recordSize)
+	j.seq++
+	binary.LittleEndian.PutUint32(buf[0:4], journalMagic)
+	buf[4] = recordPending
+	binary.LittleEndian.PutUint64(buf[8:16], j.seq)
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(stripe))
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(size))
+	pos := journalHeaderSize
+	for _, shard := range shards {
+		pos += copy(buf[pos:], shard)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(buf[8:28])
+	crc.Write(buf[journalHeaderSize:])
+	binary.LittleEndian.PutUint32(buf[28:32], crc.Sum32())
+
+	offset := j.head
+	_, err := j.disk.WriteAt(buf, offset)
+	if err != nil {
+		return 0, err
+	}
+	err = j.disk.Sync()
+	if err != nil {
+		return 0, err
+	}
+	j.head += recordSize
+	return offset, nil
+}
+
+// complete marks the record at offset as applied and syncs the mark, so
+// a record still read as pending never belongs to a stripe that a later
+// record, after a wrap or a restart at offset 0, has overwritten.
+func (j *Journal) complete(offset int64) error {
+	_, err := j.disk.WriteAt([]byte{recordApplied}, offset+4)
+	if err != nil {
+		return err
+	}
+	err = j.disk.Sync()
+	if err != nil {
+		return err
+	}
+	if j.size == 0 {
+		// Stripe writes are serialized, so every record is applied now.
+		j.head = 0
+	}
+	return nil
+}
+
+// replay calls apply for every pending record in sequence order (the
+// order scan returns them in) and marks each one applied.
+func (j *Journal) replay(apply func(stripe int64, payload []byte) error) (int, error) {
+	records, err := j.scan()
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []journalRecord
+	for _, rec := range records {
+		if rec.state == recordPending {
+			pending = append(pending, rec)
+		}
+	}
+
+	for _, rec := range pending {
+		err = apply(rec.stripe, rec.payload)
+		if err != nil {
+			return 0, err
+		}
+		err = j.complete(rec.offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(pending), j.disk.Sync()
+}
+
+// Close closes the disk holding the journal.
+func (j *Journal) Close() error {
+	return j.disk.Close()
+}
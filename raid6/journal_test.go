@@ -0,0 +1,262 @@
+package raid6
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// errCrashed is returned by every write after the injected crash point.
+var errCrashed = errors.New("simulated crash")
+
+// crashSwitch is shared by all disks of one simulated machine. After
+// budget successful writes the next write is torn and the machine stops
+// accepting writes, as if power was lost. A negative budget never crashes.
+type crashSwitch struct {
+	budget  int
+	crashed bool
+	rng     *rand.Rand
+}
+
+type crashDisk struct {
+	Disk
+	sw *crashSwitch
+}
+
+func (d *crashDisk) WriteAt(p []byte, off int64) (int, error) {
+	if d.sw.crashed {
+		return 0, errCrashed
+	}
+	if d.sw.budget == 0 {
+		// Persist a random prefix of the write to model a torn sector.
+		d.sw.crashed = true
+		n, _ := d.Disk.WriteAt(p[:d.sw.rng.Intn(len(p)+1)], off)
+		return n, errCrashed
+	}
+	if d.sw.budget > 0 {
+		d.sw.budget--
+	}
+	return d.Disk.WriteAt(p, off)
+}
+
+func (d *crashDisk) Size() (int64, error) {
+	return diskSize(d.Disk)
+}
+
+// Close leaves the underlying disk open so it survives the "reboot".
+func (d *crashDisk) Close() error {
+	return nil
+}
+
+// crashDisks returns n fresh memory disks and the same disks behind sw.
+func crashDisks(n int, sw *crashSwitch) (raw, wrapped []Disk) {
+	raw = make([]Disk, n)
+	wrapped = make([]Disk, n)
+	for i := range raw {
+		raw[i] = NewMemDisk()
+		wrapped[i] = &crashDisk{Disk: raw[i], sw: sw}
+	}
+	return raw, wrapped
+}
+
+// journaledVolume returns a volume over disks using the journal on jd.
+func journaledVolume(t *testing.T, k, m, chunkSize int, disks []Disk, jd Disk) *Volume {
+	t.Helper()
+	v, err := NewVolume(k, m, chunkSize, disks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournal(jd, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.UseJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// checkConsistent fails unless every stripe has consistent parity.
+func checkConsistent(t *testing.T, v *Volume, stripes int) {
+	t.Helper()
+	for s := 0; s < stripes; s++ {
+		valid, err := v.VerifyStripe(int64(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, ok := range valid {
+			if !ok {
+				t.Fatalf("stripe %d parity %d inconsistent after replay", s, i)
+			}
+		}
+	}
+}
+
+// TestJournalCrashConsistency checks that the journal closes the write
+// hole. Each trial fills a journaled volume, rewrites one stripe with a
+// crash injected after a random number of disk writes, "reboots" by
+// building a fresh volume over the surviving disks and replaying the
+// journal, and then requires every stripe to have consistent parity and
+// to hold either the old or the new data.
+func TestJournalCrashConsistency(t *testing.T) {
+	const stripes, trials = 4, 200
+	for _, g := range []struct{ k, m, chunkSize int }{
+		{2, 1, 16}, {4, 2, 64}, {5, 2, 64}, {6, 3, 32}, {10, 4, 8},
+	} {
+		t.Run(fmt.Sprintf("%d+%d", g.k, g.m), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(g.k*100 + g.m)))
+			for trial := 0; trial < trials; trial++ {
+				sw := &crashSwitch{budget: -1, rng: rng}
+				members, wrapped := crashDisks(g.k+g.m, sw)
+				journalDisk := NewMemDisk()
+				v := journaledVolume(t, g.k, g.m, g.chunkSize, wrapped, &crashDisk{Disk: journalDisk, sw: sw})
+
+				old := make([][]byte, stripes)
+				for s := range old {
+					old[s] = make([]byte, v.StripeSize())
+					rng.Read(old[s])
+					if err := v.WriteStripe(int64(s), old[s]); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// One journal write, one write per member and the applied mark.
+				sw.budget = rng.Intn(g.k + g.m + 2)
+				target := rng.Intn(stripes)
+				updated := make([]byte, v.StripeSize())
+				rng.Read(updated)
+				writeErr := v.WriteStripe(int64(target), updated)
+				if writeErr != nil && !errors.Is(writeErr, errCrashed) {
+					t.Fatal(writeErr)
+				}
+
+				v = journaledVolume(t, g.k, g.m, g.chunkSize, members, journalDisk)
+				checkConsistent(t, v, stripes)
+				for s := 0; s < stripes; s++ {
+					data, err := v.ReadStripe(int64(s))
+					if err != nil {
+						t.Fatal(err)
+					}
+					switch {
+					case bytes.Equal(data, old[s]) && (s != target || writeErr != nil):
+					case s == target && bytes.Equal(data, updated):
+					default:
+						t.Fatalf("trial %d: stripe %d holds neither old nor new data", trial, s)
+					}
+				}
+			}
+		})
+	}
+}
+
+// ringVolume returns a volume over disks using a ring journal of size
+// bytes on jd.
+func ringVolume(t *testing.T, disks []Disk, jd Disk, size int64) *Volume {
+	t.Helper()
+	v, err := NewVolume(4, 2, 64, disks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournal(jd, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.UseJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// TestJournalRingWrap crashes a ring journal in the write that wraps it
+// back to offset 0, or the one after, and requires the replayed stripes
+// to be consistent, and a later write and restart to keep them so.
+func TestJournalRingWrap(t *testing.T) {
+	const stripes, trials = 4, 200
+	// Two 416-byte records fit, so every other write wraps.
+	const ringSize = 1000
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < trials; trial++ {
+		sw := &crashSwitch{budget: -1, rng: rng}
+		members, wrapped := crashDisks(6, sw)
+		journalDisk := NewMemDisk()
+		v := ringVolume(t, wrapped, &crashDisk{Disk: journalDisk, sw: sw}, ringSize)
+
+		// want holds the contents of every stripe, or for a crashed
+		// write the new contents, which replay may or may not restore.
+		want := make([][]byte, stripes)
+		write := func(v *Volume, s int) error {
+			want[s] = make([]byte, v.StripeSize())
+			rng.Read(want[s])
+			return v.WriteStripe(int64(s), want[s])
+		}
+		for s := range want {
+			if err := write(v, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := rng.Intn(3); i > 0; i-- {
+			if err := write(v, rng.Intn(stripes)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sw.budget = rng.Intn(6 + 2)
+		target := rng.Intn(stripes)
+		old := want[target]
+		err := write(v, target)
+		if err != nil && !errors.Is(err, errCrashed) {
+			t.Fatal(err)
+		}
+
+		v = ringVolume(t, members, journalDisk, ringSize)
+		checkConsistent(t, v, stripes)
+		data, err := v.ReadStripe(int64(target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want[target]) && !bytes.Equal(data, old) {
+			t.Fatalf("trial %d: stripe %d holds neither old nor new data", trial, target)
+		}
+		want[target] = data
+
+		// The log must stay usable: more writes, wrapping again, survive
+		// another restart without replaying anything stale.
+		for i := 0; i < 3; i++ {
+			if err := write(v, rng.Intn(stripes)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		v = ringVolume(t, members, journalDisk, ringSize)
+		for s := range want {
+			data, err := v.ReadStripe(int64(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, want[s]) {
+				t.Fatalf("trial %d: stripe %d lost a write after restart", trial, s)
+			}
+		}
+	}
+}
+
+// TestJournalFileSize checks that a file journal is reused from offset 0
+// instead of growing with every write.
+func TestJournalFileSize(t *testing.T) {
+	journalDisk := NewMemDisk()
+	v := journaledVolume(t, 4, 2, 64, memDisks(6), journalDisk)
+	data := make([]byte, v.StripeSize())
+	for s := 0; s < 50; s++ {
+		if err := v.WriteStripe(int64(s%5), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, err := diskSize(journalDisk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size > journalHeaderSize+6*64 {
+		t.Fatalf("journal grew to %d bytes", size)
+	}
+}
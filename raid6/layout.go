@@ -0,0 +1,172 @@
+package raid6
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Layout decides which member disk holds each shard of a stripe.
+//
+// With LayoutParityDisks the parity shards always live on the last
+// parityShards disks, so those disks take every parity update. The other
+// layouts rotate the parity block by one disk per stripe, like the md
+// RAID layouts of the same names:
+//
+//   - left layouts start the parity block at disk n-m on stripe 0 and move
+//     it one disk to the left on every stripe; right layouts start it at
+//     disk 0 and move it to the right.
+//   - asymmetric layouts put the data shards on the remaining disks in
+//     disk order; symmetric layouts start the data right after the parity
+//     block and wrap around, so consecutive chunks hit every disk in turn.
+type Layout int
+
+const (
+	LayoutParityDisks Layout = iota
+	LayoutLeftAsymmetric
+	LayoutLeftSymmetric
+	LayoutRightAsymmetric
+	LayoutRightSymmetric
+)
+
+var layoutNames = []string{
+	LayoutParityDisks:     "parity-disks",
+	LayoutLeftAsymmetric:  "left-asymmetric",
+	LayoutLeftSymmetric:   "left-symmetric",
+	LayoutRightAsymmetric: "right-asymmetric",
+	LayoutRightSymmetric:  "right-symmetric",
+}
+
+func (l Layout) String() string {
+	if l < 0 || int(l) >= len(layoutNames) {
+		return fmt.Sprintf("layout(%d)", int(l))
+	}
+	return layoutNames[l]
+}
+
+// ParseLayout returns the layout with the given name, as printed by String.
+func ParseLayout(name string) (Layout, error) {
+	for l, n := range layoutNames {
+		if strings.EqualFold(n, name) {
+			return Layout(l), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown layout %q", name)
+}
+
+// Map returns the disk of every shard of stripe: entries 0..dataShards-1
+// are the data shards, followed by the parity shards.
+func (l Layout) Map(stripe int64, dataShards, parityShards int) []int {
+	n := dataShards + parityShards
+	disks := make([]int, n)
+	if l == LayoutParityDisks {
+		for i := range disks {
+			disks[i] = i
+		}
+		return disks
+	}
+
+	rotation := int(stripe % int64(n))
+	var start int
+	switch l {
+	case LayoutLeftAsymmetric, LayoutLeftSymmetric:
+		start = ((dataShards-rotation)%n + n) % n
+	default:
+		start = rotation
+	}
+	for j := 0; j < parityShards; j++ {
+		disks[dataShards+j] = (start + j) % n
+	}
+
+	switch l {
+	case LayoutLeftSymmetric, LayoutRightSymmetric:
+		for i := 0; i < dataShards; i++ {
+			disks[i] = (start + parityShards + i) % n
+		}
+	default:
+		i := 0
+		for disk := 0; disk < n; disk++ {
+			if (disk-start+n)%n >= parityShards {
+				disks[i] = disk
+				i++
+			}
+		}
+	}
+	return disks
+}
+
+// DataDisk returns the disk holding data shard i of stripe.
+func (l Layout) DataDisk(stripe int64, i, dataShards, parityShards int) int {
+	return l.Map(stripe, dataShards, parityShards)[i]
+}
+
+// ParityDisk returns the disk holding parity shard j of stripe.
+func (l Layout) ParityDisk(stripe int64, j, dataShards, parityShards int) int {
+	return l.Map(stripe, dataShards, parityShards)[dataShards+j]
+}
+
+// shardOf returns the shard of stripe that lives on disk.
+func (v *Volume) shardOf(stripe int64, disk int) int {
+	for shard, d := range v.layout.Map(stripe, v.r.dataShards, v.r.parityShards) {
+		if d == disk {
+			return shard
+		}
+	}
+	return -1
+}
+
+// stripeDisks returns the member of every shard of stripe.
+func (v *Volume) stripeDisks(stripe int64) []Disk {
+	disks := make([]Disk, v.r.totalShards)
+	for shard, d := range v.layout.Map(stripe, v.r.dataShards, v.r.parityShards) {
+		disks[shard] = v.disks[d]
+	}
+	return disks
+}
+
+// Layout returns the stripe layout of the volume.
+func (v *Volume) Layout() Layout {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.layout
+}
+
+// SetLayout selects the stripe layout. It only works on an empty array,
+// because changing it would move every chunk.
+func (v *Volume) SetLayout(l Layout) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return errReadOnly
+	}
+	if l < 0 || int(l) >= len(layoutNames) {
+		return fmt.Errorf("unknown layout %d", int(l))
+	}
+	count, err := v.stripeCount()
+	if err != nil {
+		return err
+	}
+	if count > 0 || v.reshape != nil {
+		return fmt.Errorf("cannot change the layout of an array holding data")
+	}
+	v.layout = l
+	return v.writeSuperblocks()
+}
+
+// ChunkLocation returns where logical data chunk n of the volume lives:
+// the stripe, the member disk and the byte offset on that disk.
+func (v *Volume) ChunkLocation(n int64) (stripe int64, disk int, offset int64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	g, stripe, within := v.locate(n * int64(v.chunkSize))
+	disk = g.layout.DataDisk(stripe, within/g.chunkSize, g.r.dataShards, g.r.parityShards)
+	return stripe, disk, g.chunkOffset(stripe)
+}
+
+// ParityLocations returns the member disk of every parity chunk of
+// stripe, in the geometry the stripe is currently in.
+func (v *Volume) ParityLocations(stripe int64) []int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	g := v.layoutFor(stripe)
+	return g.layout.Map(stripe, g.r.dataShards, g.r.parityShards)[g.r.dataShards:]
+}
@@ -0,0 +1,133 @@
+package raid6
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// TestLayoutMap checks the placement of the first stripes against the md
+// RAID5 layouts (one parity shard, where md and this package agree) and
+// against the documented rotation of a two-disk parity block.
+func TestLayoutMap(t *testing.T) {
+	for _, c := range []struct {
+		layout Layout
+		k, m   int
+		want   [][]int // data disks then parity disks, per stripe
+	}{
+		{LayoutParityDisks, 4, 1, [][]int{{0, 1, 2, 3, 4}, {0, 1, 2, 3, 4}, {0, 1, 2, 3, 4}}},
+		{LayoutLeftAsymmetric, 4, 1, [][]int{{0, 1, 2, 3, 4}, {0, 1, 2, 4, 3}, {0, 1, 3, 4, 2}}},
+		{LayoutLeftSymmetric, 4, 1, [][]int{{0, 1, 2, 3, 4}, {4, 0, 1, 2, 3}, {3, 4, 0, 1, 2}}},
+		{LayoutRightAsymmetric, 4, 1, [][]int{{1, 2, 3, 4, 0}, {0, 2, 3, 4, 1}, {0, 1, 3, 4, 2}}},
+		{LayoutRightSymmetric, 4, 1, [][]int{{1, 2, 3, 4, 0}, {2, 3, 4, 0, 1}, {3, 4, 0, 1, 2}}},
+
+		{LayoutParityDisks, 4, 2, [][]int{{0, 1, 2, 3, 4, 5}, {0, 1, 2, 3, 4, 5}, {0, 1, 2, 3, 4, 5}}},
+		{LayoutLeftAsymmetric, 4, 2, [][]int{{0, 1, 2, 3, 4, 5}, {0, 1, 2, 5, 3, 4}, {0, 1, 4, 5, 2, 3}}},
+		{LayoutLeftSymmetric, 4, 2, [][]int{{0, 1, 2, 3, 4, 5}, {5, 0, 1, 2, 3, 4}, {4, 5, 0, 1, 2, 3}}},
+		{LayoutRightAsymmetric, 4, 2, [][]int{{2, 3, 4, 5, 0, 1}, {0, 3, 4, 5, 1, 2}, {0, 1, 4, 5, 2, 3}}},
+		{LayoutRightSymmetric, 4, 2, [][]int{{2, 3, 4, 5, 0, 1}, {3, 4, 5, 0, 1, 2}, {4, 5, 0, 1, 2, 3}}},
+	} {
+		for stripe, want := range c.want {
+			got := c.layout.Map(int64(stripe), c.k, c.m)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %d+%d stripe %d: got %v, want %v", c.layout, c.k, c.m, stripe, got, want)
+			}
+			if d := c.layout.DataDisk(int64(stripe), 1, c.k, c.m); d != want[1] {
+				t.Errorf("%s %d+%d stripe %d: data disk 1 is %d, want %d", c.layout, c.k, c.m, stripe, d, want[1])
+			}
+			if d := c.layout.ParityDisk(int64(stripe), 0, c.k, c.m); d != want[c.k] {
+				t.Errorf("%s %d+%d stripe %d: parity disk 0 is %d, want %d", c.layout, c.k, c.m, stripe, d, want[c.k])
+			}
+		}
+	}
+}
+
+// TestLayoutPermutation checks that every layout puts the shards of a
+// stripe on distinct disks and rotates parity over all of them.
+func TestLayoutPermutation(t *testing.T) {
+	for l := range layoutNames {
+		layout := Layout(l)
+		for _, g := range []struct{ k, m int }{{2, 1}, {4, 2}, {5, 3}, {10, 4}} {
+			n := g.k + g.m
+			parityDisks := make(map[int]bool)
+			for stripe := int64(0); stripe < int64(2*n); stripe++ {
+				seen := make([]bool, n)
+				disks := layout.Map(stripe, g.k, g.m)
+				for _, d := range disks {
+					if d < 0 || d >= n || seen[d] {
+						t.Fatalf("%s %d+%d stripe %d: %v is not a permutation", layout, g.k, g.m, stripe, disks)
+					}
+					seen[d] = true
+				}
+				for _, d := range disks[g.k:] {
+					parityDisks[d] = true
+				}
+			}
+			want := n
+			if layout == LayoutParityDisks {
+				want = g.m
+			}
+			if len(parityDisks) != want {
+				t.Fatalf("%s %d+%d: parity on %d disks, want %d", layout, g.k, g.m, len(parityDisks), want)
+			}
+		}
+	}
+}
+
+// TestChunkLocation checks that ChunkLocation and ParityLocations point
+// at the member bytes a volume actually wrote.
+func TestChunkLocation(t *testing.T) {
+	for l := range layoutNames {
+		layout := Layout(l)
+		t.Run(layout.String(), func(t *testing.T) {
+			disks := memDisks(6)
+			v, err := createVolume(4, 2, 64, disks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.SetLayout(layout); err != nil {
+				t.Fatal(err)
+			}
+			data := make([]byte, 6*v.StripeSize())
+			rand.New(rand.NewSource(int64(l))).Read(data)
+			if _, err := v.WriteAt(data, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			for n := int64(0); n < int64(len(data)/64); n++ {
+				stripe, disk, offset := v.ChunkLocation(n)
+				if stripe != n/4 || offset != v.chunkOffset(stripe) {
+					t.Fatalf("chunk %d at stripe %d offset %d", n, stripe, offset)
+				}
+				chunk := make([]byte, 64)
+				if err := readFull(disks[disk], chunk, offset); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(chunk, data[n*64:(n+1)*64]) {
+					t.Fatalf("chunk %d not found on disk %d", n, disk)
+				}
+			}
+
+			for stripe := int64(0); stripe < 6; stripe++ {
+				shards := make([][]byte, 4)
+				for i := 0; i < 4; i++ {
+					shards[i] = data[(stripe*4+int64(i))*64:][:64]
+				}
+				encoded, err := v.r.encodingMatrix.Multiply(shards)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for j, disk := range v.ParityLocations(stripe) {
+					chunk := make([]byte, 64)
+					if err := readFull(disks[disk], chunk, v.chunkOffset(stripe)); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(chunk, encoded[4+j]) {
+						t.Fatalf("stripe %d: parity %d not found on disk %d", stripe, j, disk)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+
+	"raid6/raid6/gf256"
+)
+
+// LRC is an Azure-style locally repairable code. The data shards are
+// split into localGroups contiguous groups, each with one XOR local
+// parity, and globalParity Reed-Solomon parities cover all data. Shards
+// are ordered data, local parities, global parities.
+//
+// A single lost shard of a group is rebuilt from the rest of its group,
+// reading about dataShards/localGroups shards instead of dataShards.
+// Other patterns fall back to decoding with the full encoding matrix,
+// which tolerates any globalParity+1 failures and many larger patterns.
+type LRC struct {
+	dataShards   int
+	localGroups  int
+	globalParity int
+	totalShards  int
+
+	encodingMatrix Matrix
+	DiskArray      Matrix
+}
+
+// errTooManyErasures is returned if the present shards cannot span the data.
+var errTooManyErasures = errors.New("not enough independent shards to reconstruct data")
+
+// NewLRC returns an LRC codec with dataShards data shards in localGroups
+// local groups and globalParity global parities.
+func NewLRC(dataShards, localGroups, globalParity int) (*LRC, error) {
+	if dataShards <= 0 || localGroups <= 0 || globalParity < 0 {
+		return nil, errors.New("invalid data, local group or global parity count")
+	}
+	if localGroups > dataShards {
+		return nil, errors.New("more local groups than data shards")
+	}
+	l := &LRC{
+		dataShards:   dataShards,
+		localGroups:  localGroups,
+		globalParity: globalParity,
+		totalShards:  dataShards + localGroups + globalParity,
+	}
+	if l.totalShards > fieldSize {
+		return nil, errors.New("too many shards for an 8-bit field")
+	}
+	err := checkField()
+	if err != nil {
+		return nil, err
+	}
+
+	// A pyramid code: the parity rows of a Cauchy code with one parity
+	// more than the global ones are MDS, and the first is all ones.
+	// Split by group it gives the XOR local parities, and the others are
+	// the global parities. A data vector whose local parities are all
+	// zero has a zero first Cauchy parity too, so no codeword weighs less
+	// than in the Cauchy code, whose minimum distance globalParity+2
+	// makes any globalParity+1 failures recoverable.
+	cauchy, err := CauchyMatrix(dataShards, globalParity+1)
+	if err != nil {
+		return nil, err
+	}
+	l.encodingMatrix, _ = NewMatrix(l.totalShards, dataShards)
+	for i := 0; i < dataShards; i++ {
+		l.encodingMatrix[i][i] = 1
+		l.encodingMatrix[dataShards+l.group(i)][i] = 1
+	}
+	for j := 0; j < globalParity; j++ {
+		copy(l.encodingMatrix[dataShards+localGroups+j], cauchy[dataShards+1+j])
+	}
+	return l, nil
+}
+
+// group returns the local group of data shard i.
+func (l *LRC) group(i int) int {
+	return i * l.localGroups / l.dataShards
+}
+
+// TotalShards returns the number of data, local and global parity shards.
+func (l *LRC) TotalShards() int {
+	return l.totalShards
+}
+
+// EncodingMatrix returns the encoding matrix, e.g. for AnalyzeCode.
+func (l *LRC) EncodingMatrix() Matrix {
+	return l.encodingMatrix.Clone()
+}
+
+// Group returns the shards of the local group of shard: its data shards
+// followed by its local parity. Global parities belong to no group.
+func (l *LRC) Group(shard int) []int {
+	var g int
+	switch {
+	case shard < 0 || shard >= l.dataShards+l.localGroups:
+		return nil
+	case shard < l.dataShards:
+		g = l.group(shard)
+	default:
+		g = shard - l.dataShards
+	}
+	var members []int
+	for i := 0; i < l.dataShards; i++ {
+		if l.group(i) == g {
+			members = append(members, i)
+		}
+	}
+	return append(members, l.dataShards+g)
+}
+
+// Encode computes the local and global parities of the data shards and
+// stores all shards in DiskArray.
+func (l *LRC) Encode(shards [][]byte) error {
+	if len(shards) != l.dataShards {
+		return fmt.Errorf("need %d data shards, got %d", l.dataShards, len(shards))
+	}
+	encoded, err := l.encodingMatrix.Multiply(shards)
+	if err != nil {
+		return err
+	}
+	l.DiskArray = encoded
+	return nil
+}
+
+// RepairPlan returns the shards read to rebuild the missing shards of
+// DiskArray: the rest of the local group if a shard is the only one
+// missing in it, otherwise enough independent shards to decode the data.
+func (l *LRC) RepairPlan() ([]int, error) {
+	local, global := l.split()
+	read := make(map[int]bool)
+	for _, shard := range local {
+		for _, i := range l.Group(shard) {
+			if i != shard {
+				read[i] = true
+			}
+		}
+	}
+	if len(global) > 0 {
+		rows, err := l.independentRows()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range rows {
+			read[i] = true
+		}
+	}
+	var plan []int
+	for i := 0; i < l.totalShards; i++ {
+		if read[i] {
+			plan = append(plan, i)
+		}
+	}
+	return plan, nil
+}
+
+// split sorts the missing shards into those repairable from their local
+// group and those that need global decoding.
+func (l *LRC) split() (local, global []int) {
+	for i, shard := range l.DiskArray {
+		if shard != nil {
+			continue
+		}
+		group := l.Group(i)
+		if group == nil {
+			global = append(global, i)
+			continue
+		}
+		missing := 0
+		for _, j := range group {
+			if l.DiskArray[j] == nil {
+				missing++
+			}
+		}
+		if missing == 1 {
+			local = append(local, i)
+		} else {
+			global = append(global, i)
+		}
+	}
+	return local, global
+}
+
+// Reconstruct rebuilds every nil shard of DiskArray. Shards that are the
+// only loss in their local group are XORed from the group; the rest are
+// decoded from dataShards independent present shards and re-encoded.
+func (l *LRC) Reconstruct() error {
+	if len(l.DiskArray) != l.totalShards {
+		return errors.New("invalid disk array")
+	}
+	size := -1
+	for _, shard := range l.DiskArray {
+		if shard != nil {
+			size = len(shard)
+			break
+		}
+	}
+	if size < 0 {
+		return errTooManyErasures
+	}
+
+	local, global := l.split()
+	for _, shard := range local {
+		rebuilt := make([]byte, size)
+		for _, i := range l.Group(shard) {
+			if i != shard {
+				gf256.AddSlice(l.DiskArray[i], rebuilt)
+			}
+		}
+		l.DiskArray[shard] = rebuilt
+	}
+	if len(global) == 0 {
+		return nil
+	}
+
+	rows, err := l.independentRows()
+	if err != nil {
+		return err
+	}
+	sub := make(Matrix, len(rows))
+	subShards := make(Matrix, len(rows))
+	for j, i := range rows {
+		sub[j] = l.encodingMatrix[i]
+		subShards[j] = l.DiskArray[i]
+	}
+	decode, err := sub.Invert()
+	if err != nil {
+		return err
+	}
+	data, err := decode.Multiply(subShards)
+	if err != nil {
+		return err
+	}
+	for _, i := range global {
+		l.DiskArray[i] = make([]byte, size)
+		for c, coef := range l.encodingMatrix[i] {
+			gf256.MulAddSlice(coef, data[c], l.DiskArray[i])
+		}
+	}
+	return nil
+}
+
+// independentRows picks dataShards present shards whose encoding rows
+// are linearly independent, preferring data shards.
+func (l *LRC) independentRows() ([]int, error) {
+	var rows []int
+	var picked Matrix
+	for i, shard := range l.DiskArray {
+		if shard == nil {
+			continue
+		}
+		candidate := append(picked, l.encodingMatrix[i])
+		if candidate.Rank() == len(candidate) {
+			picked = candidate
+			rows = append(rows, i)
+			if len(rows) == l.dataShards {
+				return rows, nil
+			}
+		}
+	}
+	return nil, errTooManyErasures
+}
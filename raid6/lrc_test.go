@@ -0,0 +1,68 @@
+package raid6
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestLRCReconstruct(t *testing.T) {
+	for _, g := range []struct{ k, l, r int }{{6, 2, 2}, {12, 2, 2}, {14, 2, 4}, {10, 5, 1}, {8, 1, 3}} {
+		t.Run(fmt.Sprintf("%d-%d-%d", g.k, g.l, g.r), func(t *testing.T) {
+			c, err := NewLRC(g.k, g.l, g.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rng := rand.New(rand.NewSource(int64(g.k)))
+			if err := c.Encode(randomShards(rng, g.k, 64)); err != nil {
+				t.Fatal(err)
+			}
+			want := append(Matrix(nil), c.DiskArray...)
+
+			// Every pattern of r+1 failures, exhaustively or sampled.
+			opts := AnalysisOptions{Limit: 20000}
+			subsets(c.TotalShards(), g.r+1, opts, rng, func(lost []int) bool {
+				c.DiskArray = append(Matrix(nil), want...)
+				for _, i := range lost {
+					c.DiskArray[i] = nil
+				}
+				if err := c.Reconstruct(); err != nil {
+					t.Fatalf("erasing %v: %v", lost, err)
+				}
+				checkShards(t, c.DiskArray, want)
+				return true
+			})
+
+			a, err := AnalyzeCode(c.EncodingMatrix(), g.k, AnalysisOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.FaultTolerance < g.r+1 {
+				t.Fatalf("fault tolerance %d, want at least %d", a.FaultTolerance, g.r+1)
+			}
+		})
+	}
+}
+
+func TestLRCLocalRepair(t *testing.T) {
+	c, err := NewLRC(12, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Encode(randomShards(rand.New(rand.NewSource(1)), 12, 32)); err != nil {
+		t.Fatal(err)
+	}
+	want := append(Matrix(nil), c.DiskArray...)
+	c.DiskArray[3] = nil
+	plan, err := c.RepairPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(plan) != "[0 1 2 4 5 12]" {
+		t.Fatalf("repair plan %v, want the rest of the local group", plan)
+	}
+	if err := c.Reconstruct(); err != nil {
+		t.Fatal(err)
+	}
+	checkShards(t, c.DiskArray, want)
+}
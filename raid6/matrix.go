@@ -14,11 +14,13 @@ import (
 	"strings"
 )
 
-// byte[row][col]
-type matrix [][]byte
+// Matrix is a matrix over GF(2^8), indexed as m[row][col]. Addition is
+// XOR and multiplication uses the field tables in galois.go, so every
+// operation is exact and custom codes can be built from it.
+type Matrix [][]byte
 
-// newMatrix returns a matrix of zeros.
-func newMatrix(rows, cols int) (matrix, error) {
+// NewMatrix returns a matrix of zeros.
+func NewMatrix(rows, cols int) (Matrix, error) {
 	if rows <= 0 {
 		return nil, errInvalidRowSize
 	}
@@ -26,7 +28,7 @@ func newMatrix(rows, cols int) (matrix, error) {
 		return nil, errInvalidColSize
 	}
 
-	m := matrix(make([][]byte, rows))
+	m := Matrix(make([][]byte, rows))
 	for i := range m {
 		m[i] = make([]byte, cols)
 	}
@@ -35,8 +37,8 @@ func newMatrix(rows, cols int) (matrix, error) {
 
 // NewMatrixData initializes a matrix with the given row-major data.
 // Note that data is not copied from input.
-func newMatrixData(data [][]byte) (matrix, error) {
-	m := matrix(data)
+func NewMatrixData(data [][]byte) (Matrix, error) {
+	m := Matrix(data)
 	err := m.Check()
 	if err != nil {
 		return nil, err
@@ -45,8 +47,8 @@ func newMatrixData(data [][]byte) (matrix, error) {
 }
 
 // IdentityMatrix returns an identity matrix of the given size.
-func identityMatrix(size int) (matrix, error) {
-	m, err := newMatrix(size, size)
+func IdentityMatrix(size int) (Matrix, error) {
+	m, err := NewMatrix(size, size)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +67,8 @@ var errInvalidColSize = errors.New("invalid column size")
 // errColSizeMismatch is returned if the size of matrix columns mismatch.
 var errColSizeMismatch = errors.New("column size is not the same for all rows")
 
-func (m matrix) Check() error {
+// Check returns an error if the matrix is empty or its rows differ in length.
+func (m Matrix) Check() error {
 	rows := len(m)
 	if rows <= 0 {
 		return errInvalidRowSize
@@ -86,7 +89,7 @@ func (m matrix) Check() error {
 // String returns a human-readable string of the matrix contents.
 //
 // Example: [[1, 2], [3, 4]]
-func (m matrix) String() string {
+func (m Matrix) String() string {
 	rowOut := make([]string, 0, len(m))
 	for _, row := range m {
 		colOut := make([]string, 0, len(row))
@@ -100,11 +103,11 @@ func (m matrix) String() string {
 
 // Multiply multiplies this matrix (the one on the left) by another
 // matrix (the one on the right) and returns a new matrix with the result.
-func (m matrix) Multiply(right matrix) (matrix, error) {
+func (m Matrix) Multiply(right Matrix) (Matrix, error) {
 	if len(m[0]) != len(right) {
 		return nil, fmt.Errorf("columns on left (%d) is different than rows on right (%d)", len(m[0]), len(right))
 	}
-	result, _ := newMatrix(len(m), len(right[0]))
+	result, _ := NewMatrix(len(m), len(right[0]))
 	for r, row := range result {
 		for c := range row {
 			var value byte
@@ -118,12 +121,12 @@ func (m matrix) Multiply(right matrix) (matrix, error) {
 }
 
 // Augment returns the concatenation of this matrix and the matrix on the right.
-func (m matrix) Augment(right matrix) (matrix, error) {
+func (m Matrix) Augment(right Matrix) (Matrix, error) {
 	if len(m) != len(right) {
-		return nil, errMatrixSize
+		return nil, ErrMatrixSize
 	}
 
-	result, _ := newMatrix(len(m), len(m[0])+len(right[0]))
+	result, _ := NewMatrix(len(m), len(m[0])+len(right[0]))
 	for r, row := range m {
 		for c := range row {
 			result[r][c] = m[r][c]
@@ -136,24 +139,25 @@ func (m matrix) Augment(right matrix) (matrix, error) {
 	return result, nil
 }
 
-// errMatrixSize is returned if matrix dimensions are doesn't match.
-var errMatrixSize = errors.New("matrix sizes do not match")
+// ErrMatrixSize is returned if matrix dimensions do not match.
+var ErrMatrixSize = errors.New("matrix sizes do not match")
 
-func (m matrix) SameSize(n matrix) error {
+// SameSize returns ErrMatrixSize unless both matrices have the same dimensions.
+func (m Matrix) SameSize(n Matrix) error {
 	if len(m) != len(n) {
-		return errMatrixSize
+		return ErrMatrixSize
 	}
 	for i := range m {
 		if len(m[i]) != len(n[i]) {
-			return errMatrixSize
+			return ErrMatrixSize
 		}
 	}
 	return nil
 }
 
 // SubMatrix returns a part of this matrix. Data is copied.
-func (m matrix) SubMatrix(rmin, cmin, rmax, cmax int) (matrix, error) {
-	result, err := newMatrix(rmax-rmin, cmax-cmin)
+func (m Matrix) SubMatrix(rmin, cmin, rmax, cmax int) (Matrix, error) {
+	result, err := NewMatrix(rmax-rmin, cmax-cmin)
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +171,7 @@ func (m matrix) SubMatrix(rmin, cmin, rmax, cmax int) (matrix, error) {
 }
 
 // SwapRows Exchanges two rows in the matrix.
-func (m matrix) SwapRows(r1, r2 int) error {
+func (m Matrix) SwapRows(r1, r2 int) error {
 	if r1 < 0 || len(m) <= r1 || r2 < 0 || len(m) <= r2 {
 		return errInvalidRowSize
 	}
@@ -176,26 +180,26 @@ func (m matrix) SwapRows(r1, r2 int) error {
 }
 
 // IsSquare will return true if the matrix is square, otherwise false.
-func (m matrix) IsSquare() bool {
+func (m Matrix) IsSquare() bool {
 	return len(m) == len(m[0])
 }
 
-// errSingular is returned if the matrix is singular and cannot be inversed
-var errSingular = errors.New("matrix is singular")
+// ErrSingular is returned if the matrix is singular and cannot be inversed
+var ErrSingular = errors.New("matrix is singular")
 
-// errNotSquare is returned if attempting to inverse a non-square matrix.
-var errNotSquare = errors.New("only square matrices can be inverted")
+// ErrNotSquare is returned if attempting to inverse a non-square matrix.
+var ErrNotSquare = errors.New("only square matrices can be inverted")
 
 // Invert returns the inverse of this matrix.
 // Returns ErrSingular when the matrix is singular and doesn't have an inverse.
 // The matrix must be square, otherwise ErrNotSquare is returned.
-func (m matrix) Invert() (matrix, error) {
+func (m Matrix) Invert() (Matrix, error) {
 	if !m.IsSquare() {
-		return nil, errNotSquare
+		return nil, ErrNotSquare
 	}
 
 	size := len(m)
-	work, _ := identityMatrix(size)
+	work, _ := IdentityMatrix(size)
 	work, _ = m.Augment(work)
 
 	err := work.gaussianElimination()
@@ -206,7 +210,7 @@ func (m matrix) Invert() (matrix, error) {
 	return work.SubMatrix(0, size, size, size*2)
 }
 
-func (m matrix) gaussianElimination() error {
+func (m Matrix) gaussianElimination() error {
 	rows := len(m)
 	columns := len(m[0])
 	// Clear out the part below the main diagonal and scale the main
@@ -227,7 +231,7 @@ func (m matrix) gaussianElimination() error {
 		}
 		// If we couldn't find one, the matrix is singular.
 		if m[r][r] == 0 {
-			return errSingular
+			return ErrSingular
 		}
 		// Scale to 1.
 		if m[r][r] != 1 {
@@ -263,3 +267,196 @@ func (m matrix) gaussianElimination() error {
 	}
 	return nil
 }
+
+// Clone returns a copy of the matrix.
+func (m Matrix) Clone() Matrix {
+	c := make(Matrix, len(m))
+	for r, row := range m {
+		c[r] = append([]byte(nil), row...)
+	}
+	return c
+}
+
+// Transpose returns the transpose of the matrix.
+func (m Matrix) Transpose() Matrix {
+	t, _ := NewMatrix(len(m[0]), len(m))
+	for r, row := range m {
+		for c, v := range row {
+			t[c][r] = v
+		}
+	}
+	return t
+}
+
+// RowEchelon returns the reduced row echelon form of the matrix and the
+// pivot column of each non-zero row. The matrix is not modified.
+func (m Matrix) RowEchelon() (Matrix, []int) {
+	work := m.Clone()
+	var pivots []int
+	for c := 0; c < len(work[0]) && len(pivots) < len(work); c++ {
+		r := len(pivots)
+		pivot := -1
+		for below := r; below < len(work); below++ {
+			if work[below][c] != 0 {
+				pivot = below
+				break
+			}
+		}
+		if pivot < 0 {
+			continue
+		}
+		work.SwapRows(r, pivot)
+		if work[r][c] != 1 {
+			scale := galOneOver(work[r][c])
+			for c1 := c; c1 < len(work[r]); c1++ {
+				work[r][c1] = galMultiply(work[r][c1], scale)
+			}
+		}
+		// Clear the column above and below the pivot.
+		for other := range work {
+			if other == r || work[other][c] == 0 {
+				continue
+			}
+			scale := work[other][c]
+			for c1 := c; c1 < len(work[other]); c1++ {
+				work[other][c1] ^= galMultiply(scale, work[r][c1])
+			}
+		}
+		pivots = append(pivots, c)
+	}
+	return work, pivots
+}
+
+// Rank returns the number of linearly independent rows of the matrix.
+func (m Matrix) Rank() int {
+	_, pivots := m.RowEchelon()
+	return len(pivots)
+}
+
+// Determinant returns the determinant of a square matrix. In a field of
+// characteristic 2 row swaps do not change its sign, so it is the product
+// of the pivots found during elimination. It returns ErrNotSquare for a
+// matrix that is not square.
+func (m Matrix) Determinant() (byte, error) {
+	if !m.IsSquare() {
+		return 0, ErrNotSquare
+	}
+	work := m.Clone()
+	det := byte(1)
+	for c := range work {
+		pivot := -1
+		for r := c; r < len(work); r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return 0, nil
+		}
+		work.SwapRows(c, pivot)
+		det = galMultiply(det, work[c][c])
+		scale := galOneOver(work[c][c])
+		for r := c + 1; r < len(work); r++ {
+			if work[r][c] == 0 {
+				continue
+			}
+			factor := galMultiply(work[r][c], scale)
+			for c1 := c; c1 < len(work); c1++ {
+				work[r][c1] ^= galMultiply(factor, work[c][c1])
+			}
+		}
+	}
+	return det, nil
+}
+
+// NullSpace returns a basis of the vectors x with m * x = 0, one vector
+// per row. It returns nil if the columns of m are independent.
+func (m Matrix) NullSpace() Matrix {
+	reduced, pivots := m.RowEchelon()
+	cols := len(m[0])
+	isPivot := make([]bool, cols)
+	for _, c := range pivots {
+		isPivot[c] = true
+	}
+
+	// Each free column gives one basis vector: set it to 1 and solve the
+	// pivot variables, which in characteristic 2 is a copy of the column.
+	var basis Matrix
+	for free := 0; free < cols; free++ {
+		if isPivot[free] {
+			continue
+		}
+		x := make([]byte, cols)
+		x[free] = 1
+		for r, c := range pivots {
+			x[c] = reduced[r][free]
+		}
+		basis = append(basis, x)
+	}
+	return basis
+}
+
+// ErrInconsistent is returned by Solve if the system has no solution.
+var ErrInconsistent = errors.New("system of equations has no solution")
+
+// Solve returns a vector x with a * x = b. If a has a non-trivial null
+// space the solution is not unique; the one returned has all free
+// variables set to zero, and adding any combination of NullSpace vectors
+// gives the others. It returns ErrMatrixSize if b does not have one entry
+// per row of a and ErrInconsistent if there is no solution.
+func Solve(a Matrix, b []byte) ([]byte, error) {
+	if len(b) != len(a) {
+		return nil, ErrMatrixSize
+	}
+	column := make(Matrix, len(b))
+	for r, v := range b {
+		column[r] = []byte{v}
+	}
+	augmented, err := a.Augment(column)
+	if err != nil {
+		return nil, err
+	}
+
+	reduced, pivots := augmented.RowEchelon()
+	cols := len(a[0])
+	x := make([]byte, cols)
+	for r, c := range pivots {
+		if c == cols {
+			return nil, ErrInconsistent
+		}
+		x[c] = reduced[r][cols]
+	}
+	return x, nil
+}
+
+// SolveBlocks solves a * x = b where every entry of b and x is a block
+// of bytes: row i of b is the block a[i] combines to. a may have more
+// rows than columns; independent rows are picked, as the pivot columns
+// of its transpose, and reduced together with their blocks by Gaussian
+// elimination. It returns ErrMatrixSize if a is empty or does not match
+// b, ErrSingular if the columns are dependent, and does not check that
+// the surplus rows agree.
+func SolveBlocks(a Matrix, b Matrix) (Matrix, error) {
+	if len(a) == 0 || len(a) != len(b) {
+		return nil, ErrMatrixSize
+	}
+	cols := len(a[0])
+	_, pivots := a.Transpose().RowEchelon()
+	if len(pivots) < cols {
+		return nil, ErrSingular
+	}
+	work := make(Matrix, cols)
+	for r, i := range pivots {
+		work[r] = append(append([]byte(nil), a[i]...), b[i]...)
+	}
+	err := work.gaussianElimination()
+	if err != nil {
+		return nil, err
+	}
+	x := make(Matrix, cols)
+	for r := range x {
+		x[r] = work[r][cols:]
+	}
+	return x, nil
+}
@@ -0,0 +1,61 @@
+package raid6
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSolve(t *testing.T) {
+	a := fixedVandermond(6, 4)[2:]
+	want := []byte{1, 2, 3, 4}
+	b := make([]byte, len(a))
+	for i, row := range a {
+		for j, c := range row {
+			b[i] ^= galMultiply(c, want[j])
+		}
+	}
+	x, err := Solve(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(x, want) {
+		t.Fatalf("Solve = %v, want %v", x, want)
+	}
+
+	blocks := make(Matrix, len(b))
+	for i, v := range b {
+		blocks[i] = []byte{v, v}
+	}
+	xs, err := SolveBlocks(a, blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, block := range xs {
+		if block[0] != want[i] || block[1] != want[i] {
+			t.Fatalf("SolveBlocks row %d = %v, want %d", i, block, want[i])
+		}
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	singular := Matrix{{1, 2}, {1, 2}}
+	if _, err := singular.Invert(); !errors.Is(err, ErrSingular) {
+		t.Errorf("Invert of a singular matrix: %v", err)
+	}
+	if _, err := (Matrix{{1, 2}}).Invert(); !errors.Is(err, ErrNotSquare) {
+		t.Errorf("Invert of a non-square matrix: %v", err)
+	}
+	if _, err := Solve(singular, []byte{1, 2}); !errors.Is(err, ErrInconsistent) {
+		t.Errorf("Solve of an inconsistent system: %v", err)
+	}
+	if _, err := Solve(singular, []byte{1}); !errors.Is(err, ErrMatrixSize) {
+		t.Errorf("Solve with a short right-hand side: %v", err)
+	}
+	if _, err := SolveBlocks(Matrix{{1, 2}, {2, 4}, {1, 2}}, make(Matrix, 3)); !errors.Is(err, ErrSingular) {
+		t.Errorf("SolveBlocks with dependent columns: %v", err)
+	}
+	if _, err := SolveBlocks(nil, nil); !errors.Is(err, ErrMatrixSize) {
+		t.Errorf("SolveBlocks of an empty system: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"raid6/raid6/gf256"
+)
+
+// ByteRange is the half-open range [Offset, Offset+Length) of a shard.
+type ByteRange struct {
+	Offset int
+	Length int
+}
+
+// errRange is returned for a range outside the shards.
+var errRange = errors.New("byte range outside the shard")
+
+// decodeMatrix returns the matrix that computes the missing shards from
+// the first dataShards present shards, listed in present:
+//
+//	encoding[missing] * inverse(encoding[present]) * present = missing
+func (r *raid6) decodeMatrix(present, missing []int) (Matrix, error) {
+	sub := make(Matrix, r.dataShards)
+	for j, i := range present[:r.dataShards] {
+		sub[j] = r.encodingMatrix[i]
+	}
+	inverse, err := sub.Invert()
+	if err != nil {
+		return nil, err
+	}
+	rows := make(Matrix, len(missing))
+	for j, i := range missing {
+		rows[j] = r.encodingMatrix[i]
+	}
+	return rows.Multiply(inverse)
+}
+
+// segment is a stretch of columns with one erasure pattern.
+type segment struct {
+	start, end int
+	missing    []bool
+}
+
+// segments splits [0, size) at every range boundary and returns the
+// stretches that miss something, with the shards they miss.
+func segments(missing [][]ByteRange, size int) []segment {
+	cuts := []int{0, size}
+	for _, ranges := range missing {
+		for _, br := range ranges {
+			cuts = append(cuts, br.Offset, br.Offset+br.Length)
+		}
+	}
+	sort.Ints(cuts)
+
+	var out []segment
+	for c := 0; c+1 < len(cuts); c++ {
+		start, end := cuts[c], cuts[c+1]
+		if start == end {
+			continue
+		}
+		lost := make([]bool, len(missing))
+		touched := false
+		for i, ranges := range missing {
+			for _, br := range ranges {
+				if br.Offset <= start && end <= br.Offset+br.Length {
+					lost[i] = true
+					touched = true
+				}
+			}
+		}
+		if !touched {
+			continue
+		}
+		// Merge with the previous stretch if it misses the same shards.
+		if n := len(out); n > 0 && out[n-1].end == start && sameShards(out[n-1].missing, lost) {
+			out[n-1].end = end
+			continue
+		}
+		out = append(out, segment{start: start, end: end, missing: lost})
+	}
+	return out
+}
+
+func sameShards(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ReconstructRanges rebuilds the byte ranges listed per shard in missing,
+// indexed like DiskArray. Nil shards of DiskArray are missing entirely.
+// Columns are grouped by which shards miss them, one decode matrix is
+// inverted per group and every range of the group is rebuilt from the
+// same columns of the other shards. So as long as no column misses more
+// than parityShards shards, data comes back even if every shard has lost
+// some sectors.
+func (r *raid6) ReconstructRanges(missing [][]ByteRange) error {
+	if len(missing) != r.totalShards || len(r.DiskArray) != r.totalShards {
+		return errors.New("invalid missing range list")
+	}
+	size := -1
+	for _, shard := range r.DiskArray {
+		if shard != nil {
+			size = len(shard)
+		}
+	}
+	if size < 0 {
+		return errors.New("not enough valid disks to reconstruct data")
+	}
+	all := make([][]ByteRange, r.totalShards)
+	for i, ranges := range missing {
+		for _, br := range ranges {
+			if br.Offset < 0 || br.Length < 0 || br.Offset+br.Length > size {
+				return fmt.Errorf("disk %d: %w", i, errRange)
+			}
+		}
+		all[i] = ranges
+		if r.DiskArray[i] == nil {
+			all[i] = []ByteRange{{0, size}}
+		}
+	}
+
+	// Check every segment and build its decode matrix before touching
+	// DiskArray, so a failure leaves it as it was.
+	type plan struct {
+		segment
+		present, lost []int
+		decode        Matrix
+	}
+	var plans []plan
+	decoders := make(map[string]Matrix)
+	for _, seg := range segments(all, size) {
+		p := plan{segment: seg}
+		for i, m := range seg.missing {
+			if m {
+				p.lost = append(p.lost, i)
+			} else {
+				p.present = append(p.present, i)
+			}
+		}
+		if len(p.present) < r.dataShards {
+			return fmt.Errorf("bytes %d-%d: %d shards missing, at most %d can be rebuilt", seg.start, seg.end, len(p.lost), r.parityShards)
+		}
+		key := fmt.Sprint(p.lost)
+		decode, ok := decoders[key]
+		if !ok {
+			var err error
+			decode, err = r.decodeMatrix(p.present, p.lost)
+			if err != nil {
+				return err
+			}
+			decoders[key] = decode
+		}
+		p.decode = decode
+		plans = append(plans, p)
+	}
+
+	for i, shard := range r.DiskArray {
+		if shard == nil {
+			r.DiskArray[i] = make([]byte, size)
+		}
+	}
+	for _, p := range plans {
+		for j, i := range p.lost {
+			out := r.DiskArray[i][p.start:p.end]
+			clear(out)
+			for c, coef := range p.decode[j] {
+				gf256.MulAddSlice(coef, r.DiskArray[p.present[c]][p.start:p.end], out)
+			}
+		}
+	}
+	return nil
+}
+
+// ReconstructRange returns bytes [offset, offset+length) of every data
+// shard. shards are in encoding order with nil for missing ones. Only
+// that window of the first dataShards present shards is read and only
+// the window of the missing data shards is decoded, so a small read from
+// a degraded stripe costs in proportion to its size, not the shard size.
+// Present data shards are returned as slices of shards.
+func (r *raid6) ReconstructRange(shards [][]byte, offset, length int) ([][]byte, error) {
+	if len(shards) != r.totalShards {
+		return nil, errors.New("invalid shard list")
+	}
+	var present, missing []int
+	for i, shard := range shards {
+		switch {
+		case shard == nil:
+			if i < r.dataShards {
+				missing = append(missing, i)
+			}
+		case len(present) < r.dataShards:
+			if offset < 0 || length < 0 || offset+length > len(shard) {
+				return nil, fmt.Errorf("disk %d: %w", i, errRange)
+			}
+			present = append(present, i)
+		}
+	}
+
+	window := make([][]byte, r.dataShards)
+	for i := range window {
+		if shards[i] != nil {
+			window[i] = shards[i][offset : offset+length]
+		}
+	}
+	if len(missing) == 0 {
+		return window, nil
+	}
+	if len(present) < r.dataShards {
+		return nil, errors.New("not enough valid disks to reconstruct data")
+	}
+	decode, err := r.decodeMatrix(present, missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		window[i] = make([]byte, length)
+		for c, coef := range decode[j] {
+			gf256.MulAddSlice(coef, shards[present[c]][offset:offset+length], window[i])
+		}
+	}
+	return window, nil
+}
@@ -0,0 +1,177 @@
+package raid6
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// encodedStripe returns a 6+2 codec with an encoded stripe of size-byte
+// shards, and a copy of the shards.
+func encodedStripe(t *testing.T, size int) (*raid6, Matrix) {
+	t.Helper()
+	r, err := newRaid6(6, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Encode(randomShards(rand.New(rand.NewSource(1)), 6, size))
+	want := make(Matrix, len(r.DiskArray))
+	for i, shard := range r.DiskArray {
+		want[i] = append([]byte(nil), shard...)
+	}
+	return r, want
+}
+
+func TestReconstructRanges(t *testing.T) {
+	r, want := encodedStripe(t, 4096)
+
+	// Disk 2 is gone and every other shard lost a sector, each in its own
+	// place, except two that overlap on 1024-1536.
+	r.DiskArray[2] = nil
+	missing := make([][]ByteRange, 8)
+	for i := range missing {
+		if i != 2 {
+			missing[i] = []ByteRange{{Offset: 512 * i, Length: 512}}
+		}
+	}
+	missing[3] = append(missing[3], ByteRange{Offset: 1024, Length: 512})
+	for i, ranges := range missing {
+		for _, br := range ranges {
+			clear(r.DiskArray[i][br.Offset : br.Offset+br.Length])
+		}
+	}
+	if err := r.ReconstructRanges(missing); err != nil {
+		t.Fatal(err)
+	}
+	checkShards(t, r.DiskArray, want)
+}
+
+func TestReconstructRangesFailureLeavesArray(t *testing.T) {
+	r, want := encodedStripe(t, 4096)
+
+	// The first segments are recoverable, but 3000-3100 misses three
+	// shards, so nothing may be written, not even the lost disk.
+	r.DiskArray[0] = nil
+	missing := make([][]ByteRange, 8)
+	missing[1] = []ByteRange{{Offset: 0, Length: 512}}
+	missing[4] = []ByteRange{{Offset: 3000, Length: 100}}
+	missing[7] = []ByteRange{{Offset: 3000, Length: 100}}
+	clear(r.DiskArray[1][:512])
+	if err := r.ReconstructRanges(missing); err == nil {
+		t.Fatal("three shards missing a column reconstructed")
+	}
+	if r.DiskArray[0] != nil {
+		t.Fatal("lost disk allocated on failure")
+	}
+	if !bytes.Equal(r.DiskArray[1][:512], make([]byte, 512)) {
+		t.Fatal("range rebuilt on failure")
+	}
+
+	missing[1] = []ByteRange{{Offset: 4000, Length: 200}}
+	if err := r.ReconstructRanges(missing); err == nil {
+		t.Fatal("range past the shard accepted")
+	}
+	if r.DiskArray[0] != nil {
+		t.Fatal("lost disk allocated on failure")
+	}
+	for i := 2; i < 8; i++ {
+		if !bytes.Equal(r.DiskArray[i], want[i]) {
+			t.Fatalf("shard %d changed", i)
+		}
+	}
+}
+
+func TestReconstructRange(t *testing.T) {
+	r, want := encodedStripe(t, 4096)
+	shards := append(Matrix(nil), want...)
+	shards[1] = nil
+	shards[4] = nil
+
+	got, err := r.ReconstructRange(shards, 1000, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := make(Matrix, 6)
+	for i := range windows {
+		windows[i] = want[i][1000:1300]
+	}
+	checkShards(t, got, windows)
+
+	if _, err := r.ReconstructRange(shards, 4000, 200); err == nil {
+		t.Fatal("window past the shard accepted")
+	}
+	shards[7] = nil
+	if _, err := r.ReconstructRange(shards, 0, 100); err == nil {
+		t.Fatal("three missing shards decoded")
+	}
+}
+
+// countingDisk counts the bytes read from a disk.
+type countingDisk struct {
+	Disk
+	read int
+}
+
+func (d *countingDisk) ReadAt(p []byte, off int64) (int, error) {
+	n, err := d.Disk.ReadAt(p, off)
+	d.read += n
+	return n, err
+}
+
+func (d *countingDisk) Size() (int64, error) {
+	return diskSize(d.Disk)
+}
+
+func TestReadAtDegraded(t *testing.T) {
+	counted := make([]*countingDisk, 6)
+	disks := make([]Disk, len(counted))
+	for i, d := range memDisks(len(counted)) {
+		counted[i] = &countingDisk{Disk: d}
+		disks[i] = counted[i]
+	}
+	v, err := createVolume(4, 2, 64, disks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 10*v.StripeSize())
+	rand.New(rand.NewSource(1)).Read(want)
+	if _, err := v.WriteAt(want, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 3} {
+		if err := v.FailMember(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make([]byte, len(want))
+	if _, err := v.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("degraded read returned wrong data")
+	}
+
+	// A small read of a failed member's chunk decodes just its columns.
+	for _, d := range counted {
+		d.read = 0
+	}
+	_, disk, _ := v.ChunkLocation(4)
+	if disk != 0 && disk != 3 {
+		t.Fatalf("chunk 4 lives on disk %d, want a failed one", disk)
+	}
+	p := make([]byte, 10)
+	if _, err := v.ReadAt(p, 4*64+20); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, want[4*64+20:4*64+30]) {
+		t.Fatal("degraded read returned wrong data")
+	}
+	read := 0
+	for _, d := range counted {
+		read += d.read
+	}
+	if read != 4*len(p) {
+		t.Fatalf("read %d bytes to decode %d", read, len(p))
+	}
+}
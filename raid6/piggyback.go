@@ -0,0 +1,341 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+
+	"raid6/raid6/gf256"
+)
+
+// Piggyback is a Hitchhiker-style piggybacked Reed-Solomon code. Each
+// shard is split into two halves, sub-stripes a and b, which are encoded
+// separately with the Reed-Solomon encoding matrix. The data shards are
+// split into groups, and a half of some parity shard, its carrier, adds
+// a combination of the group's halves of the other sub-stripe.
+//
+// With three or more parity shards there are parityShards-1 groups, and
+// the b half of parity j+1 carries the XOR of the a halves of group j:
+//
+//	data i:     a_i           b_i
+//	parity 0:   f_0(a)        f_0(b)
+//	parity j+1: f_{j+1}(a)    f_{j+1}(b) + sum of a_i over group j
+//
+// With two parity shards that would leave a single group of every data
+// shard, so parity 1 carries in both halves instead: its b half the a
+// halves of the first group and its a half the b halves, weighted by
+// coefficients u_i, of the second:
+//
+//	parity 1:   f_1(a) + sum of u_i*b_i over group 1
+//	            f_1(b) + sum of a_i over group 0
+//
+// Any dataShards shards still decode everything: Reconstruct solves the
+// halves of the present shards together. The two carriers of parity 1
+// depend on each other, so NewPiggyback picks each u_i such that every
+// pair of lost data shards stays solvable, which covers all patterns of
+// two failures.
+//
+// Parity 0 never carries. Repairing a lost data shard reads the halves of
+// the other sub-stripe from the other data shards and parity 0, decodes
+// it, reads the carrier half and learns the group's combination, and
+// reads the same half of the rest of the group: dataShards + group size
+// halves instead of dataShards full shards. That saves (dataShards -
+// group size) / (2 * dataShards) of the repair traffic: 25% for any
+// k+2 with even k and for 6+3, and about 33% for 10+4.
+type Piggyback struct {
+	dataShards   int
+	parityShards int
+	totalShards  int
+
+	encodingMatrix Matrix
+	// expanded encodes the halves: row 2*i+h is half h of shard i over
+	// the data halves a_0..a_{k-1}, b_0..b_{k-1}.
+	expanded  Matrix
+	carriers  []carrier
+	carrierOf []int // index into carriers per data shard, -1 for none
+
+	DiskArray Matrix
+}
+
+// carrier is a parity half that adds coefs times the other half of the
+// data shards in group.
+type carrier struct {
+	shard, half int
+	group       []int
+	coefs       []byte
+}
+
+// errOddShard is returned if shards cannot be split into two halves.
+var errOddShard = errors.New("piggyback shards must have an even length")
+
+// NewPiggyback returns a piggybacked code. With a single parity shard
+// there is nothing to piggyback on and it behaves like Reed-Solomon.
+func NewPiggyback(dataShards, parityShards int) (*Piggyback, error) {
+	r, err := newRaid6(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	p := &Piggyback{
+		dataShards:     dataShards,
+		parityShards:   parityShards,
+		totalShards:    r.totalShards,
+		encodingMatrix: r.encodingMatrix,
+		carrierOf:      make([]int, dataShards),
+	}
+	for i := range p.carrierOf {
+		p.carrierOf[i] = -1
+	}
+	switch {
+	case parityShards == 2:
+		split := (dataShards + 1) / 2
+		p.addCarrier(dataShards+1, 1, 0, split)
+		p.addCarrier(dataShards+1, 0, split, dataShards)
+	case parityShards > 2:
+		groups := parityShards - 1
+		for g := 0; g < groups; g++ {
+			p.addCarrier(dataShards+1+g, 1, (g*dataShards+groups-1)/groups, ((g+1)*dataShards+groups-1)/groups)
+		}
+	}
+	p.expand()
+	if parityShards == 2 {
+		err = p.pickCoefficients()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// addCarrier lets half of parity shard carry the data shards from..to-1.
+func (p *Piggyback) addCarrier(shard, half, from, to int) {
+	c := carrier{shard: shard, half: half}
+	for d := from; d < to; d++ {
+		c.group = append(c.group, d)
+		c.coefs = append(c.coefs, 1)
+		p.carrierOf[d] = len(p.carriers)
+	}
+	if len(c.group) > 0 {
+		p.carriers = append(p.carriers, c)
+	}
+}
+
+// expand builds the encoding matrix of the halves from the Reed-Solomon
+// rows and the carriers.
+func (p *Piggyback) expand() {
+	k := p.dataShards
+	p.expanded, _ = NewMatrix(2*p.totalShards, 2*k)
+	for i, row := range p.encodingMatrix {
+		for h := 0; h < 2; h++ {
+			copy(p.expanded[2*i+h][h*k:], row)
+		}
+	}
+	for _, c := range p.carriers {
+		row := p.expanded[2*c.shard+c.half]
+		for j, d := range c.group {
+			row[(1-c.half)*k+d] = c.coefs[j]
+		}
+	}
+}
+
+// pickCoefficients chooses the coefficient of every data shard carried
+// in the a half of parity 1 such that losing it together with any data
+// shard of the other group leaves the four halves solvable from the two
+// parities. Each shard of the other group rules out at most one value.
+func (p *Piggyback) pickCoefficients() error {
+	if len(p.carriers) < 2 {
+		return nil
+	}
+	k := p.dataShards
+	other, c := p.carriers[0], &p.carriers[1]
+	row := p.expanded[2*c.shard+c.half]
+	for j, d := range c.group {
+		found := false
+		for u := 1; u < fieldSize && !found; u++ {
+			row[k+d] = byte(u)
+			found = true
+			for _, i := range other.group {
+				if !p.solvable([]int{i, d}) {
+					found = false
+					break
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("no piggyback coefficient for data shard %d", d)
+		}
+		c.coefs[j] = row[k+d]
+	}
+	return nil
+}
+
+// solvable reports whether the halves of the lost data shards follow
+// from the halves of every parity shard.
+func (p *Piggyback) solvable(lost []int) bool {
+	k := p.dataShards
+	system, _ := NewMatrix(2*p.parityShards, 2*len(lost))
+	for r := range system {
+		for j, d := range lost {
+			system[r][j] = p.expanded[2*k+r][d]
+			system[r][len(lost)+j] = p.expanded[2*k+r][k+d]
+		}
+	}
+	return system.Rank() == 2*len(lost)
+}
+
+// halves returns the a and b halves of every shard; missing shards give nil.
+func halves(shards Matrix) (a, b Matrix) {
+	a = make(Matrix, len(shards))
+	b = make(Matrix, len(shards))
+	for i, shard := range shards {
+		if shard != nil {
+			a[i] = shard[:len(shard)/2]
+			b[i] = shard[len(shard)/2:]
+		}
+	}
+	return a, b
+}
+
+// Encode splits every data shard into its two halves, encodes both
+// sub-stripes with the piggybacks and stores all shards in DiskArray.
+func (p *Piggyback) Encode(shards [][]byte) error {
+	if len(shards) != p.dataShards {
+		return fmt.Errorf("need %d data shards, got %d", p.dataShards, len(shards))
+	}
+	for _, shard := range shards {
+		if len(shard)%2 != 0 || len(shard) != len(shards[0]) {
+			return errOddShard
+		}
+	}
+	a, b := halves(shards)
+	p.DiskArray = p.join(append(a, b...))
+	return nil
+}
+
+// join encodes the data halves, a_0..a_{k-1} then b_0..b_{k-1}, into
+// every shard.
+func (p *Piggyback) join(data Matrix) Matrix {
+	encoded, _ := p.expanded.Multiply(data)
+	shards := make(Matrix, p.totalShards)
+	for i := range shards {
+		shards[i] = append(append([]byte(nil), encoded[2*i]...), encoded[2*i+1]...)
+	}
+	return shards
+}
+
+// decode recovers the data rows of a sub-stripe without piggybacks from
+// the first dataShards present shards, as ReconstructDataDisk does.
+func (p *Piggyback) decode(sub Matrix) (Matrix, error) {
+	rows := make(Matrix, 0, p.dataShards)
+	present := make(Matrix, 0, p.dataShards)
+	for i, shard := range sub {
+		if shard != nil && len(rows) < p.dataShards {
+			rows = append(rows, p.encodingMatrix[i])
+			present = append(present, shard)
+		}
+	}
+	if len(rows) < p.dataShards {
+		return nil, errors.New("not enough valid disks to reconstruct data")
+	}
+	inverse, err := rows.Invert()
+	if err != nil {
+		return nil, err
+	}
+	return inverse.Multiply(present)
+}
+
+// Reconstruct rebuilds every nil shard of DiskArray from any dataShards
+// present shards by solving the halves of the present shards for the
+// data halves and encoding the missing shards again.
+func (p *Piggyback) Reconstruct() error {
+	if len(p.DiskArray) != p.totalShards {
+		return errors.New("invalid disk array")
+	}
+	a, b := halves(p.DiskArray)
+	var rows, known Matrix
+	for i := range p.DiskArray {
+		if a[i] != nil {
+			rows = append(rows, p.expanded[2*i], p.expanded[2*i+1])
+			known = append(known, a[i], b[i])
+		}
+	}
+	if len(rows) < 2*p.dataShards {
+		return errors.New("not enough valid disks to reconstruct data")
+	}
+	data, err := SolveBlocks(rows, known)
+	if err != nil {
+		return err
+	}
+
+	rebuilt := p.join(data)
+	for i, shard := range p.DiskArray {
+		if shard == nil {
+			p.DiskArray[i] = rebuilt[i]
+		}
+	}
+	return nil
+}
+
+// RepairShard rebuilds the single missing shard i of DiskArray and
+// returns the number of bytes read from other shards. Data shards use the
+// piggyback path described on Piggyback; parity shards, and shards of a
+// stripe with other losses, fall back to Reconstruct.
+func (p *Piggyback) RepairShard(i int) (int, error) {
+	if i < 0 || i >= p.totalShards || p.DiskArray[i] != nil {
+		return 0, fmt.Errorf("shard %d is not missing", i)
+	}
+	missing := 0
+	size := 0
+	for _, shard := range p.DiskArray {
+		if shard == nil {
+			missing++
+		} else {
+			size = len(shard)
+		}
+	}
+	if i >= p.dataShards || p.carrierOf[i] < 0 || missing > 1 {
+		return p.dataShards * size, p.Reconstruct()
+	}
+	c := p.carriers[p.carrierOf[i]]
+	h := c.half
+	half := func(shard []byte, h int) []byte {
+		return shard[h*size/2 : (h+1)*size/2]
+	}
+
+	// Decode the carrier's sub-stripe from the other data shards and
+	// parity 0, whose halves carry no piggyback.
+	sub := make(Matrix, p.totalShards)
+	for d := 0; d <= p.dataShards; d++ {
+		if d != i {
+			sub[d] = half(p.DiskArray[d], h)
+		}
+	}
+	data, err := p.decode(sub)
+	if err != nil {
+		return 0, err
+	}
+	read := p.dataShards * size / 2
+
+	// The carrier half minus f(sub-stripe) is the group's combination of
+	// the other half; the rest of the group is read and removed from it.
+	other := append([]byte(nil), half(p.DiskArray[c.shard], h)...)
+	for d, coef := range p.encodingMatrix[c.shard] {
+		gf256.MulAddSlice(coef, data[d], other)
+	}
+	read += size / 2
+	var coef byte
+	for j, d := range c.group {
+		if d == i {
+			coef = c.coefs[j]
+			continue
+		}
+		gf256.MulAddSlice(c.coefs[j], half(p.DiskArray[d], 1-h), other)
+		read += size / 2
+	}
+	gf256.MulSlice(gf256.Inv(coef), other, other)
+
+	if h == 1 {
+		p.DiskArray[i] = append(other, data[i]...)
+	} else {
+		p.DiskArray[i] = append(data[i], other...)
+	}
+	return read, nil
+}
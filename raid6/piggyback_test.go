@@ -0,0 +1,74 @@
+package raid6
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestPiggybackReconstruct(t *testing.T) {
+	for _, g := range []struct{ k, m int }{{4, 1}, {4, 2}, {5, 2}, {10, 2}, {6, 3}, {10, 4}, {30, 2}} {
+		t.Run(fmt.Sprintf("%d+%d", g.k, g.m), func(t *testing.T) {
+			p, err := NewPiggyback(g.k, g.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rng := rand.New(rand.NewSource(int64(g.k)))
+			data := randomShards(rng, g.k, 64)
+			if err := p.Encode(data); err != nil {
+				t.Fatal(err)
+			}
+			checkShards(t, p.DiskArray[:g.k], data)
+			want := append(Matrix(nil), p.DiskArray...)
+
+			// Every pattern of m failures.
+			subsets(g.k+g.m, g.m, AnalysisOptions{Limit: 20000}, rng, func(lost []int) bool {
+				p.DiskArray = append(Matrix(nil), want...)
+				for _, i := range lost {
+					p.DiskArray[i] = nil
+				}
+				if err := p.Reconstruct(); err != nil {
+					t.Fatalf("erasing %v: %v", lost, err)
+				}
+				checkShards(t, p.DiskArray, want)
+				return true
+			})
+		})
+	}
+}
+
+func TestPiggybackRepairTraffic(t *testing.T) {
+	for _, g := range []struct {
+		k, m    int
+		percent int // repair traffic saved on average over the data shards
+	}{{4, 2, 25}, {6, 2, 25}, {10, 2, 25}, {6, 3, 25}, {10, 4, 33}} {
+		t.Run(fmt.Sprintf("%d+%d", g.k, g.m), func(t *testing.T) {
+			p, err := NewPiggyback(g.k, g.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const size = 600
+			if err := p.Encode(randomShards(rand.New(rand.NewSource(1)), g.k, size)); err != nil {
+				t.Fatal(err)
+			}
+			want := append(Matrix(nil), p.DiskArray...)
+			total := 0
+			for i := 0; i < g.k+g.m; i++ {
+				p.DiskArray = append(Matrix(nil), want...)
+				p.DiskArray[i] = nil
+				read, err := p.RepairShard(i)
+				if err != nil {
+					t.Fatalf("shard %d: %v", i, err)
+				}
+				checkShards(t, p.DiskArray, want)
+				if i < g.k {
+					total += read
+				}
+			}
+			saved := 100 - 100*total/(g.k*g.k*size)
+			if saved < g.percent {
+				t.Fatalf("saved %d%% of the repair traffic, want %d%%", saved, g.percent)
+			}
+		})
+	}
+}
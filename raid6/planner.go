@@ -0,0 +1,128 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// PlanConfig describes the disks and the durability target of the planner.
+type PlanConfig struct {
+	Disks              int
+	DiskBytes          float64
+	AFR                float64 // annual failure rate of a disk
+	RebuildBytesPerSec float64
+	URE                float64 // latent sector errors per bit read
+	// TargetNines is the required annual durability, e.g. 11 for a
+	// probability of losing data within a year of at most 1e-11.
+	TargetNines float64
+}
+
+// GeometryPlan holds the analytical figures of one (k, m) geometry.
+type GeometryPlan struct {
+	DataShards   int
+	ParityShards int
+
+	MTTDL         float64 // mean time to data loss in years
+	AnnualLoss    float64 // probability of losing data within a year
+	Nines         float64 // -log10(AnnualLoss)
+	Overhead      float64 // raw bytes stored per usable byte, (k+m)/k
+	UsableBytes   float64 // usable capacity of the array
+	RebuildReads  float64 // bytes read per byte rebuilt
+	SmallWriteIOs int     // disk I/Os of a read-modify-write of one chunk
+	RebuildDays   float64 // time to rebuild one member
+	MeetsTarget   bool
+}
+
+// errNoGeometry is returned if no geometry reaches the durability target.
+var errNoGeometry = errors.New("no geometry reaches the durability target")
+
+// PlanGeometry computes the MTTDL of a k+m array from a Markov chain
+// whose state is the number of failed members. Failures arrive at rate
+// (n-i)λ in state i, and one member is rebuilt at a time at rate μ. A
+// rebuild in the state without redundancy left fails with the
+// probability of hitting a latent sector error while reading k members,
+// which loses data like one more failure.
+func PlanGeometry(dataShards, parityShards int, cfg PlanConfig) (GeometryPlan, error) {
+	var p GeometryPlan
+	if dataShards <= 0 || parityShards <= 0 {
+		return p, errors.New("invalid data or parity shards")
+	}
+	if cfg.AFR <= 0 || cfg.AFR >= 1 {
+		return p, errors.New("AFR must be between 0 and 1")
+	}
+	if cfg.DiskBytes <= 0 || cfg.RebuildBytesPerSec <= 0 {
+		return p, errors.New("disk size and rebuild rate must be positive")
+	}
+	if cfg.URE < 0 || cfg.URE >= 1 {
+		return p, errors.New("URE must be between 0 and 1")
+	}
+
+	n := dataShards + parityShards
+	lambda := -math.Log(1 - cfg.AFR)
+	rebuild := cfg.DiskBytes / cfg.RebuildBytesPerSec / 3600 / hoursPerYear
+	mu := 1 / rebuild
+	pLatent := -math.Expm1(8 * cfg.DiskBytes * float64(dataShards) * math.Log1p(-cfg.URE))
+
+	// The chain is a birth-death process, so the expected time to move
+	// from state i to i+1 follows tau_i = (1 + d_i tau_{i-1}) / b_i, with
+	// b_i the rate towards loss and d_i the repair rate. Summing these
+	// positive terms stays accurate where solving the system does not.
+	var mttdl, tau float64
+	for i := 0; i <= parityShards; i++ {
+		b := float64(n-i) * lambda
+		d := 0.0
+		if i > 0 {
+			d = mu
+		}
+		if i == parityShards {
+			b += mu * pLatent
+			d = mu * (1 - pLatent)
+		}
+		tau = (1 + d*tau) / b
+		mttdl += tau
+	}
+
+	p = GeometryPlan{
+		DataShards:    dataShards,
+		ParityShards:  parityShards,
+		MTTDL:         mttdl,
+		AnnualLoss:    -math.Expm1(-1 / mttdl),
+		Overhead:      float64(n) / float64(dataShards),
+		UsableBytes:   cfg.DiskBytes * float64(dataShards),
+		RebuildReads:  float64(dataShards),
+		SmallWriteIOs: 2 * (1 + parityShards),
+		RebuildDays:   rebuild * 365.25,
+	}
+	p.Nines = -math.Log10(p.AnnualLoss)
+	p.MeetsTarget = p.Nines >= cfg.TargetNines
+	return p, nil
+}
+
+// RecommendGeometry evaluates every geometry using all cfg.Disks disks,
+// from one parity shard up, and returns them together with the one with
+// the most usable capacity that meets the durability target. If none
+// does, the most durable geometry is returned with errNoGeometry.
+func RecommendGeometry(cfg PlanConfig) ([]GeometryPlan, GeometryPlan, error) {
+	if cfg.Disks < 2 || cfg.Disks > fieldSize {
+		return nil, GeometryPlan{}, fmt.Errorf("disk count must be between 2 and %d", fieldSize)
+	}
+	var plans []GeometryPlan
+	for m := 1; m < cfg.Disks; m++ {
+		p, err := PlanGeometry(cfg.Disks-m, m, cfg)
+		if err != nil {
+			return nil, GeometryPlan{}, err
+		}
+		plans = append(plans, p)
+	}
+	best := plans[0]
+	for _, p := range plans {
+		if p.MeetsTarget {
+			return plans, p, nil
+		}
+		if p.MTTDL > best.MTTDL {
+			best = p
+		}
+	}
+	return plans, best, errNoGeometry
+}
@@ -0,0 +1,74 @@
+package raid6
+
+import (
+	"errors"
+	"testing"
+)
+
+var planConfig = PlanConfig{
+	Disks:              12,
+	DiskBytes:          16e12,
+	AFR:                0.02,
+	RebuildBytesPerSec: 100e6,
+	URE:                1e-15,
+	TargetNines:        11,
+}
+
+// TestPlanGeometryParity checks that every extra parity shard raises the
+// MTTDL of a fixed number of data shards.
+func TestPlanGeometryParity(t *testing.T) {
+	last := 0.0
+	for m := 1; m <= 4; m++ {
+		p, err := PlanGeometry(10, m, planConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.MTTDL <= last {
+			t.Fatalf("10+%d: MTTDL %g years, not above %g", m, p.MTTDL, last)
+		}
+		if p.Overhead != float64(10+m)/10 || p.SmallWriteIOs != 2*(1+m) {
+			t.Fatalf("10+%d: overhead %g, small write I/Os %d", m, p.Overhead, p.SmallWriteIOs)
+		}
+		last = p.MTTDL
+	}
+	if _, err := PlanGeometry(10, 0, planConfig); err == nil {
+		t.Fatal("PlanGeometry accepted zero parity shards")
+	}
+}
+
+// TestRecommendGeometry checks that the recommendation meets the target
+// with the most usable capacity, and that an unreachable target is
+// reported.
+func TestRecommendGeometry(t *testing.T) {
+	plans, best, err := RecommendGeometry(planConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != planConfig.Disks-1 {
+		t.Fatalf("%d plans for %d disks", len(plans), planConfig.Disks)
+	}
+	if !best.MeetsTarget || best.Nines < planConfig.TargetNines {
+		t.Fatalf("recommended %d+%d has %.1f nines, want %g", best.DataShards, best.ParityShards, best.Nines, planConfig.TargetNines)
+	}
+	for i, p := range plans {
+		if p.DataShards+p.ParityShards != planConfig.Disks {
+			t.Fatalf("plan %d+%d does not use %d disks", p.DataShards, p.ParityShards, planConfig.Disks)
+		}
+		if i > 0 && p.MTTDL <= plans[i-1].MTTDL {
+			t.Fatalf("MTTDL of %d+%d not above %d+%d", p.DataShards, p.ParityShards, plans[i-1].DataShards, plans[i-1].ParityShards)
+		}
+		if p.MeetsTarget && p.UsableBytes > best.UsableBytes {
+			t.Fatalf("%d+%d meets the target with more capacity than %d+%d", p.DataShards, p.ParityShards, best.DataShards, best.ParityShards)
+		}
+	}
+
+	cfg := planConfig
+	cfg.TargetNines = 1000
+	_, best, err = RecommendGeometry(cfg)
+	if !errors.Is(err, errNoGeometry) {
+		t.Fatalf("unreachable target: %v", err)
+	}
+	if best.ParityShards != cfg.Disks-1 {
+		t.Fatalf("most durable geometry is %d+%d", best.DataShards, best.ParityShards)
+	}
+}
@@ -0,0 +1,213 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+
+	"raid6/raid6/gf256"
+)
+
+// ProductCode protects a group of stripes in two dimensions. Every stripe
+// is a row of dataShards data and parityShards parity chunks, encoded
+// like the Reed-Solomon codec. The group of dataStripes stripes is then
+// extended by parityStripes parity stripes, each column encoded with a
+// Vandermonde matrix over the stripes in the same field:
+//
+//	           data disks      parity disks
+//	stripe 0   d d d d d       p p
+//	  ...      d d d d d       p p
+//	parity     c c c c c       c c   column parity, also a valid row
+//
+// Both codes are linear, so the column parities of the parity columns
+// are also the row parities of the parity stripes, and every row and
+// every column is a codeword. Reconstruct alternates between rows and
+// columns, repairing any with few enough erasures, until nothing is left
+// or no line can make progress. A whole failed disk erases one column in
+// every stripe, which the rows repair, while a stripe with more than
+// parityShards bad chunks, e.g. latent sector errors on top of the
+// failure, is repaired through its columns.
+type ProductCode struct {
+	dataShards    int
+	parityShards  int
+	dataStripes   int
+	parityStripes int
+
+	rowMatrix    Matrix
+	columnMatrix Matrix
+
+	// Stripes holds every chunk by stripe and disk; nil is erased.
+	Stripes []Matrix
+}
+
+// NewProductCode returns a product code of stripes with dataShards data
+// and parityShards parity chunks, grouped by dataStripes with
+// parityStripes column parity stripes.
+func NewProductCode(dataShards, parityShards, dataStripes, parityStripes int) (*ProductCode, error) {
+	r, err := newRaid6(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if dataStripes <= 0 || parityStripes <= 0 {
+		return nil, errors.New("invalid data or parity stripes")
+	}
+	if dataStripes+parityStripes > fieldSize {
+		return nil, errors.New("too many stripes for an 8-bit field")
+	}
+	return &ProductCode{
+		dataShards:    dataShards,
+		parityShards:  parityShards,
+		dataStripes:   dataStripes,
+		parityStripes: parityStripes,
+		rowMatrix:     r.encodingMatrix,
+		columnMatrix:  fixedVandermond(dataStripes+parityStripes, dataStripes),
+	}, nil
+}
+
+// Encode encodes data, indexed by stripe and data shard, into Stripes.
+// All chunks must have the same length.
+func (p *ProductCode) Encode(data [][][]byte) error {
+	if len(data) != p.dataStripes {
+		return fmt.Errorf("need %d data stripes, got %d", p.dataStripes, len(data))
+	}
+	stripes := make([]Matrix, p.dataStripes+p.parityStripes)
+	for s, chunks := range data {
+		if len(chunks) != p.dataShards {
+			return fmt.Errorf("stripe %d: need %d data shards, got %d", s, p.dataShards, len(chunks))
+		}
+		for _, chunk := range chunks {
+			if len(chunk) != len(data[0][0]) {
+				return errors.New("chunks must have the same length")
+			}
+		}
+		encoded, err := p.rowMatrix.Multiply(chunks)
+		if err != nil {
+			return err
+		}
+		stripes[s] = encoded
+	}
+	size := len(data[0][0])
+	for s := p.dataStripes; s < len(stripes); s++ {
+		stripes[s] = make(Matrix, p.dataShards+p.parityShards)
+		for d := range stripes[s] {
+			stripes[s][d] = make([]byte, size)
+			for i, coef := range p.columnMatrix[s] {
+				gf256.MulAddSlice(coef, stripes[i][d], stripes[s][d])
+			}
+		}
+	}
+	p.Stripes = stripes
+	return nil
+}
+
+// EraseDisk erases the chunks of a disk in every stripe.
+func (p *ProductCode) EraseDisk(disk int) error {
+	if disk < 0 || disk >= p.dataShards+p.parityShards {
+		return errors.New("invalid disk number")
+	}
+	for _, stripe := range p.Stripes {
+		stripe[disk] = nil
+	}
+	return nil
+}
+
+// EraseChunk erases the chunk of one stripe on one disk, e.g. an
+// unreadable sector.
+func (p *ProductCode) EraseChunk(stripe, disk int) error {
+	if stripe < 0 || stripe >= len(p.Stripes) || disk < 0 || disk >= len(p.Stripes[stripe]) {
+		return errors.New("invalid stripe or disk number")
+	}
+	p.Stripes[stripe][disk] = nil
+	return nil
+}
+
+// Erased returns the number of erased chunks.
+func (p *ProductCode) Erased() int {
+	n := 0
+	for _, stripe := range p.Stripes {
+		for _, chunk := range stripe {
+			if chunk == nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// fillErasures rebuilds the nil entries of line, a codeword of the
+// systematic encoding matrix, from the first present entries. It reports
+// false if too many are missing.
+func fillErasures(encoding Matrix, line [][]byte) (bool, error) {
+	k := len(encoding[0])
+	var missing []int
+	rows := make(Matrix, 0, k)
+	present := make(Matrix, 0, k)
+	for i, chunk := range line {
+		switch {
+		case chunk == nil:
+			missing = append(missing, i)
+		case len(rows) < k:
+			rows = append(rows, encoding[i])
+			present = append(present, chunk)
+		}
+	}
+	if len(missing) == 0 || len(rows) < k {
+		return false, nil
+	}
+	inverse, err := rows.Invert()
+	if err != nil {
+		return false, err
+	}
+	data, err := inverse.Multiply(present)
+	if err != nil {
+		return false, err
+	}
+	for _, i := range missing {
+		line[i] = make([]byte, len(present[0]))
+		for j, coef := range encoding[i] {
+			gf256.MulAddSlice(coef, data[j], line[i])
+		}
+	}
+	return true, nil
+}
+
+// Reconstruct rebuilds erased chunks by decoding rows and columns in
+// turn until all are back. It fails if a pass repairs nothing while
+// chunks are still erased.
+func (p *ProductCode) Reconstruct() error {
+	if len(p.Stripes) != p.dataStripes+p.parityStripes {
+		return errors.New("invalid stripe group")
+	}
+	for s, stripe := range p.Stripes {
+		if len(stripe) != p.dataShards+p.parityShards {
+			return fmt.Errorf("stripe %d: need %d chunks, got %d", s, p.dataShards+p.parityShards, len(stripe))
+		}
+	}
+	for p.Erased() > 0 {
+		progress := false
+		for s, stripe := range p.Stripes {
+			filled, err := fillErasures(p.rowMatrix, stripe)
+			if err != nil {
+				return fmt.Errorf("stripe %d: %w", s, err)
+			}
+			progress = progress || filled
+		}
+		for d := 0; d < p.dataShards+p.parityShards; d++ {
+			column := make([][]byte, len(p.Stripes))
+			for s, stripe := range p.Stripes {
+				column[s] = stripe[d]
+			}
+			filled, err := fillErasures(p.columnMatrix, column)
+			if err != nil {
+				return fmt.Errorf("disk %d: %w", d, err)
+			}
+			for s, stripe := range p.Stripes {
+				stripe[d] = column[s]
+			}
+			progress = progress || filled
+		}
+		if !progress {
+			return fmt.Errorf("%d chunks cannot be recovered", p.Erased())
+		}
+	}
+	return nil
+}
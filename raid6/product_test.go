@@ -0,0 +1,92 @@
+package raid6
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// productGroup returns an encoded 6+2 product code over 8 stripes with
+// one column parity stripe, and a copy of its chunks.
+func productGroup(t *testing.T) (*ProductCode, []Matrix) {
+	t.Helper()
+	p, err := NewProductCode(6, 2, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	data := make([][][]byte, 8)
+	for s := range data {
+		data[s] = randomShards(rng, 6, 32)
+	}
+	if err := p.Encode(data); err != nil {
+		t.Fatal(err)
+	}
+	for s := range data {
+		checkShards(t, p.Stripes[s][:6], data[s])
+	}
+	want := make([]Matrix, len(p.Stripes))
+	for s, stripe := range p.Stripes {
+		want[s] = append(Matrix(nil), stripe...)
+	}
+	return p, want
+}
+
+func TestProductCodeReconstruct(t *testing.T) {
+	p, want := productGroup(t)
+
+	// Two failed disks use up every row's parity; the latent sector
+	// errors on the survivors are left to the column parity.
+	for _, disk := range []int{1, 4} {
+		if err := p.EraseDisk(disk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range [][2]int{{3, 7}, {0, 0}, {5, 2}, {8, 6}} {
+		if err := p.EraseChunk(c[0], c[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Erased() != 2*9+4 {
+		t.Fatalf("%d chunks erased", p.Erased())
+	}
+	if err := p.Reconstruct(); err != nil {
+		t.Fatal(err)
+	}
+	for s := range want {
+		checkShards(t, p.Stripes[s], want[s])
+	}
+}
+
+func TestProductCodeUnrecoverable(t *testing.T) {
+	p, _ := productGroup(t)
+
+	// Two bad chunks in one column on top of two failed disks exceed
+	// both the row and the column parity.
+	for _, disk := range []int{1, 4} {
+		if err := p.EraseDisk(disk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []int{2, 6} {
+		if err := p.EraseChunk(s, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Reconstruct(); err == nil {
+		t.Fatal("reconstructed beyond the product code")
+	}
+}
+
+// TestProductCodeStripeWidth checks that Reconstruct rejects a stripe with
+// too few or too many chunks instead of indexing past it.
+func TestProductCodeStripeWidth(t *testing.T) {
+	for _, width := range []int{7, 9} {
+		p, _ := productGroup(t)
+		stripe := make(Matrix, width)
+		copy(stripe, p.Stripes[3])
+		p.Stripes[3] = stripe
+		if err := p.Reconstruct(); err == nil {
+			t.Fatalf("reconstructed a stripe of %d chunks", width)
+		}
+	}
+}
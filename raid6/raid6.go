@@ -12,14 +12,14 @@ type raid6 struct {
 	dataShards     int
 	parityShards   int
 	totalShards    int
-	encodingMatrix matrix
-	DiskArray      matrix
+	encodingMatrix Matrix
+	DiskArray      Matrix
 }
 
-func fixedVandermond(rows, cols int) matrix {
+func fixedVandermond(rows, cols int) Matrix {
 	// Generate a fixed Vandermonde matrix based on
 	// https://web.eecs.utk.edu/~jplank/plank/papers/CS-03-504.html
-	result, _ := newMatrix(rows, cols)
+	result, _ := NewMatrix(rows, cols)
 
 	for r, row := range result {
 		for c := range row {
@@ -54,26 +54,42 @@ func fixedVandermond(rows, cols int) matrix {
 	return result
 }
 
-func BuildRaidSystem(dataShards, parityShards int) (*raid6, error) {
+// newRaid6 builds the codec for the given geometry without allocating
+// a disk array. It is shared by BuildRaidSystem and Volume.
+func newRaid6(dataShards, parityShards int) (*raid6, error) {
 	if dataShards <= 0 || parityShards <= 0 {
 		return nil, errors.New("invalid data or parity shards")
 	}
+	if dataShards+parityShards > fieldSize {
+		return nil, errors.New("too many shards for an 8-bit field")
+	}
+	err := checkField()
+	if err != nil {
+		return nil, err
+	}
 
 	r := raid6{
 		dataShards:   dataShards,
 		parityShards: parityShards,
 		totalShards:  dataShards + parityShards,
 	}
-
 	r.encodingMatrix = fixedVandermond(r.totalShards, r.dataShards)
-	r.DiskArray, _ = newMatrix(r.totalShards, 5000)
+	return &r, nil
+}
+
+func BuildRaidSystem(dataShards, parityShards int) (*raid6, error) {
+	r, err := newRaid6(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	r.DiskArray, _ = NewMatrix(r.totalShards, 5000)
 
 	fmt.Printf("Build Disk Array: %d, %d \n", len(r.DiskArray), len(r.DiskArray[0]))
 	fmt.Printf("Data Shards: %d \n", r.dataShards)
 	fmt.Printf("Parity Shards: %d \n", r.parityShards)
 	fmt.Println()
 
-	return &r, nil
+	return r, nil
 }
 
 func (r *raid6) Encode(shards [][]byte) {
@@ -86,7 +102,7 @@ func (r *raid6) Encode(shards [][]byte) {
 	r.DiskArray, _ = r.encodingMatrix.Multiply(shards)
 }
 
-func (r *raid6) Verify() ([]bool, matrix) {
+func (r *raid6) Verify() ([]bool, Matrix) {
 	// Verify assumes error detected is the result of a bit flip
 	// This function cannot detect erasure.
 	// To detect erasure, we need to be notified which disk is corrupted.
@@ -113,13 +129,18 @@ func (r *raid6) ReconstructCorruption() error {
 	validParityList, calculated_parity := r.Verify()
 
 	nValidParity := 0
-	for _, v := range validParityList {
+	nPresentParity := 0
+	for i, v := range validParityList {
 		if v {
 			nValidParity++
 		}
+		if r.DiskArray[i+r.dataShards] != nil {
+			nPresentParity++
+		}
 	}
 
-	if nValidParity == 0 {
+	// If every parity shard is erased there is nothing to contradict the data.
+	if nValidParity == 0 && nPresentParity > 0 {
 		return errors.New("possible data disk corruption. cannot recover from data corruption")
 	}
 
@@ -196,7 +217,7 @@ func (r *raid6) ReconstructDataDisk(validDisks []bool) error {
 	// Also build a square subEncodingMatrix that contains only the row with intact disks
 
 	subShards := make([][]byte, r.dataShards)
-	subEncodingMatrix, _ := newMatrix(r.dataShards, r.dataShards)
+	subEncodingMatrix, _ := NewMatrix(r.dataShards, r.dataShards)
 	subMatrixRow := 0
 	for matrixRow := 0; matrixRow < r.totalShards && subMatrixRow < r.dataShards; matrixRow++ {
 		if validDisks[matrixRow] {
@@ -242,6 +263,22 @@ func (r *raid6) CreateBitFlip(nShard int, nBit int) error {
 	}
 }
 
+// FlipBit flips a single bit of one byte in a shard to simulate bit rot.
+// CreateBitFlip is FlipBit with bit 0.
+func (r *raid6) FlipBit(nShard, nByte, bit int) error {
+	if nShard < 0 || nShard >= len(r.DiskArray) || bit < 0 || bit > 7 {
+		return errors.New("invalid shard number or bit number")
+	}
+	if r.DiskArray[nShard] == nil {
+		return errors.New("cannot create bit flip in a nil shard")
+	}
+	if nByte < 0 || nByte >= len(r.DiskArray[nShard]) {
+		return errors.New("invalid byte number")
+	}
+	r.DiskArray[nShard][nByte] ^= 1 << bit
+	return nil
+}
+
 func (r *raid6) DropShard(nShard int) error {
 	// Create error in a specific shard
 	if nShard >= len(r.DiskArray) {
@@ -0,0 +1,187 @@
+package raid6
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// hoursPerYear converts rebuild times to the years used for lifetimes.
+const hoursPerYear = 24 * 365.25
+
+// ReliabilityConfig describes an array for the reliability simulator.
+// ParityShards may be zero to model an array without redundancy as a
+// baseline; it loses data with its first disk failure.
+type ReliabilityConfig struct {
+	DataShards   int
+	ParityShards int
+
+	// AFR is the probability that a disk fails within its first year.
+	// Lifetimes follow a Weibull distribution with shape AFRShape:
+	// 1 gives a constant failure rate, below 1 infant mortality and
+	// above 1 wear-out. Zero means 1.
+	AFR      float64
+	AFRShape float64
+
+	// DiskBytes is the capacity of a member and RebuildBytesPerSec the
+	// rate at which a replacement is rebuilt; a failed disk is replaced
+	// immediately and is back after DiskBytes/RebuildBytesPerSec.
+	DiskBytes          float64
+	RebuildBytesPerSec float64
+
+	// URE is the probability that reading a bit hits a latent sector
+	// error. Rebuilds read DataShards members in full.
+	URE float64
+
+	Years  float64 // mission time of one trial
+	Trials int
+	Seed   int64
+}
+
+// ReliabilityResult summarizes the trials of a simulation.
+type ReliabilityResult struct {
+	Trials          int
+	Losses          int     // trials that lost data within the mission time
+	LatentLosses    int     // losses caused by a latent sector error during a rebuild
+	LossProbability float64 // Losses / Trials
+	// MTTDL in years, estimated as the total simulated time divided by
+	// the number of losses. It is +Inf if no trial lost data.
+	MTTDL       float64
+	Failures    int     // disk failures over all trials
+	RebuildDays float64 // time to rebuild one member
+}
+
+// SimulateReliability runs seeded Monte Carlo trials of disk failures and
+// rebuilds. Whether an erasure pattern loses data is decided by running
+// ReconstructDisk on a disk array with the failed members dropped, so the
+// result follows the codec's real reconstruction rules.
+func SimulateReliability(cfg ReliabilityConfig) (ReliabilityResult, error) {
+	var res ReliabilityResult
+	if cfg.AFR <= 0 || cfg.AFR >= 1 {
+		return res, errors.New("AFR must be between 0 and 1")
+	}
+	if cfg.DiskBytes <= 0 || cfg.RebuildBytesPerSec <= 0 || cfg.Years <= 0 || cfg.Trials <= 0 {
+		return res, errors.New("disk size, rebuild rate, years and trials must be positive")
+	}
+	if cfg.URE < 0 || cfg.URE >= 1 {
+		return res, errors.New("URE must be between 0 and 1")
+	}
+	total := cfg.DataShards + cfg.ParityShards
+	var r *raid6
+	if cfg.ParityShards != 0 || cfg.DataShards <= 0 {
+		var err error
+		r, err = newRaid6(cfg.DataShards, cfg.ParityShards)
+		if err != nil {
+			return res, err
+		}
+	}
+	// Without parity there is no codec, and every erasure loses data.
+	recoverable := func(erased []bool, extra int) bool {
+		return r != nil && r.recoverable(erased, extra)
+	}
+
+	shape := cfg.AFRShape
+	if shape == 0 {
+		shape = 1
+	}
+	// Scale of the Weibull distribution, in years, with P(T < 1) = AFR.
+	scale := math.Pow(-math.Log(1-cfg.AFR), -1/shape)
+	rebuild := cfg.DiskBytes / cfg.RebuildBytesPerSec / 3600 / hoursPerYear
+	// Probability that one rebuild hits at least one latent sector error.
+	bits := 8 * cfg.DiskBytes * float64(cfg.DataShards)
+	pLatent := -math.Expm1(bits * math.Log1p(-cfg.URE))
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	lifetime := func() float64 {
+		return scale * math.Pow(-math.Log(1-rng.Float64()), 1/shape)
+	}
+
+	res.Trials = cfg.Trials
+	res.RebuildDays = rebuild * 365.25
+	var exposure float64
+	erased := make([]bool, total)
+	// next holds the time of the next event of every member: its failure
+	// while it is working, the end of its rebuild while it is failed.
+	next := make([]float64, total)
+	for trial := 0; trial < cfg.Trials; trial++ {
+		for i := range next {
+			erased[i] = false
+			next[i] = lifetime()
+		}
+		now := 0.0
+		for {
+			disk := 0
+			for i, t := range next {
+				if t < next[disk] {
+					disk = i
+				}
+			}
+			now = next[disk]
+			if now >= cfg.Years {
+				now = cfg.Years
+				break
+			}
+
+			if !erased[disk] {
+				res.Failures++
+				erased[disk] = true
+				next[disk] = now + rebuild
+				if !recoverable(erased, -1) {
+					res.Losses++
+					break
+				}
+				continue
+			}
+
+			// The rebuild reads the surviving members; a latent sector error
+			// erases one more chunk in the stripe being rebuilt.
+			if rng.Float64() < pLatent {
+				var survivors []int
+				for i, e := range erased {
+					if !e {
+						survivors = append(survivors, i)
+					}
+				}
+				if !recoverable(erased, survivors[rng.Intn(len(survivors))]) {
+					res.Losses++
+					res.LatentLosses++
+					break
+				}
+			}
+			erased[disk] = false
+			next[disk] = now + lifetime()
+		}
+		exposure += now
+	}
+
+	res.LossProbability = float64(res.Losses) / float64(res.Trials)
+	res.MTTDL = math.Inf(1)
+	if res.Losses > 0 {
+		res.MTTDL = exposure / float64(res.Losses)
+	}
+	return res, nil
+}
+
+// recoverable reports whether ReconstructDisk can rebuild a stripe with
+// the erased members, and member extra if it is not negative, dropped.
+func (r *raid6) recoverable(erased []bool, extra int) bool {
+	data := make(Matrix, r.dataShards)
+	for i := range data {
+		data[i] = []byte{byte(i + 1)}
+	}
+	r.Encode(data)
+	for i := range r.DiskArray {
+		if erased[i] || i == extra {
+			r.DiskArray[i] = nil
+		}
+	}
+	if r.ReconstructDisk() != nil {
+		return false
+	}
+	for _, shard := range r.DiskArray {
+		if shard == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package raid6
+
+import (
+	"reflect"
+	"testing"
+)
+
+func reliabilityConfig(parityShards int) ReliabilityConfig {
+	return ReliabilityConfig{
+		DataShards:         10,
+		ParityShards:       parityShards,
+		AFR:                0.05,
+		DiskBytes:          4e12,
+		RebuildBytesPerSec: 100e6,
+		URE:                1e-15,
+		Years:              5,
+		Trials:             2000,
+		Seed:               1,
+	}
+}
+
+// TestSimulateReliability checks that an array without parity loses data
+// with its first failure, that parity makes losses rare, and that a seed
+// reproduces the same result.
+func TestSimulateReliability(t *testing.T) {
+	none, err := SimulateReliability(reliabilityConfig(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// P(no failure of 10 disks in 5 years) = 0.95^50, about 0.08.
+	if none.LossProbability < 0.85 || none.MTTDL > 5 {
+		t.Fatalf("m=0: loss probability %g, MTTDL %g years", none.LossProbability, none.MTTDL)
+	}
+	if none.Losses != none.Failures || none.LatentLosses != 0 {
+		t.Fatalf("m=0: %d losses from %d failures, %d latent", none.Losses, none.Failures, none.LatentLosses)
+	}
+
+	two, err := SimulateReliability(reliabilityConfig(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if two.LossProbability > 0.01 || two.MTTDL <= none.MTTDL {
+		t.Fatalf("m=2: loss probability %g, MTTDL %g years", two.LossProbability, two.MTTDL)
+	}
+
+	again, err := SimulateReliability(reliabilityConfig(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(none, again) {
+		t.Fatalf("same seed gave %+v and %+v", none, again)
+	}
+	cfg := reliabilityConfig(0)
+	cfg.Seed = 2
+	other, err := SimulateReliability(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(none, other) {
+		t.Fatal("different seeds gave the same result")
+	}
+}
+
+func TestSimulateReliabilityArguments(t *testing.T) {
+	for _, change := range []func(*ReliabilityConfig){
+		func(c *ReliabilityConfig) { c.AFR = 0 },
+		func(c *ReliabilityConfig) { c.AFR = 1 },
+		func(c *ReliabilityConfig) { c.Trials = 0 },
+		func(c *ReliabilityConfig) { c.URE = -1 },
+		func(c *ReliabilityConfig) { c.DataShards = 0 },
+	} {
+		cfg := reliabilityConfig(2)
+		change(&cfg)
+		if _, err := SimulateReliability(cfg); err == nil {
+			t.Errorf("SimulateReliability accepted %+v", cfg)
+		}
+	}
+}
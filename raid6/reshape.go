@@ -0,0 +1,373 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A reshape rewrites the array from one geometry to another, one stripe
+// at a time, while ReadAt and WriteAt keep working. The volume switches to
+// the new geometry immediately; a view of the old geometry serves the
+// part of the address space that has not been rewritten yet.
+//
+// When the number of data shards grows (or stays the same), stripes are
+// rewritten from the front: new stripe n only overwrites chunk position n,
+// whose old contents all belong to new stripes <= n. When it shrinks the
+// same argument holds walking backwards from the end. Either way the only
+// data at risk is the stripe being written, which is protected by the
+// journal, and the position is persisted in the superblocks after every
+// stripe so the reshape survives restarts.
+
+// errReshaping is returned by stripe-level calls on a region that is still
+// in the old geometry, and by operations that cannot run during a reshape.
+var errReshaping = errors.New("reshape in progress")
+
+type reshapeState struct {
+	// old is a view of the array in the previous geometry. It shares the
+	// member slice with the volume, so failed members are seen by both.
+	old *Volume
+	// position is the next stripe to rewrite when walking forward, or the
+	// first rewritten stripe when walking backward.
+	position int64
+	// stripes is the number of stripes in the new geometry.
+	stripes int64
+	// size is the logical size of the array in bytes.
+	size     int64
+	backward bool
+}
+
+// oldView returns a volume over disks in geometry r without journal or
+// superblock, sharing the layout of v.
+func (v *Volume) oldView(r *raid6, disks []Disk) *Volume {
+	return &Volume{
+		r:             r,
+		disks:         disks,
+		chunkSize:     v.chunkSize,
+		dataOffset:    v.dataOffset,
+		regionStripes: v.regionStripes,
+		dirty:         make([]byte, dirtyMapBytes),
+		intent:        make([]byte, bitmapBytes),
+		layout:        v.layout,
+	}
+}
+
+// layoutFor returns the geometry that chunk position stripe is currently in.
+func (v *Volume) layoutFor(stripe int64) *Volume {
+	rs := v.reshape
+	if rs == nil {
+		return v
+	}
+	if rs.backward == (stripe >= rs.position) {
+		return v
+	}
+	return rs.old
+}
+
+// nextStripe returns the stripe the next reshape step rewrites.
+func (rs *reshapeState) nextStripe() int64 {
+	if rs.backward {
+		return rs.position - 1
+	}
+	return rs.position
+}
+
+func (rs *reshapeState) done() bool {
+	if rs.backward {
+		return rs.position <= 0
+	}
+	return rs.position >= rs.stripes
+}
+
+// StartReshape begins converting the array to dataShards+parityShards.
+// Disks in added join as new members after the existing ones; when the
+// new geometry needs fewer members, the trailing ones leave the array
+// once the reshape finishes. Changing the number of data shards, or any
+// reshape of a rotating layout, moves data chunks onto positions that
+// still hold old data, so it requires a journal.
+func (v *Volume) StartReshape(dataShards, parityShards int, added []Disk) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return errReadOnly
+	}
+	if v.reshape != nil {
+		return errReshaping
+	}
+	if v.degraded() {
+		return errors.New("cannot reshape a degraded array")
+	}
+	if (dataShards != v.r.dataShards || v.layout != LayoutParityDisks) && v.journal == nil {
+		return errors.New("changing the number of data shards or reshaping a rotating layout requires a journal")
+	}
+	r, err := newRaid6(dataShards, parityShards)
+	if err != nil {
+		return err
+	}
+	if len(v.disks)+len(added) < r.totalShards {
+		return fmt.Errorf("new geometry needs %d members, have %d", r.totalShards, len(v.disks)+len(added))
+	}
+
+	count, err := v.stripeCount()
+	if err != nil {
+		return err
+	}
+	size := count * int64(v.stripeSize())
+	newStripeSize := int64(dataShards * v.chunkSize)
+
+	all := append(v.disks[:len(v.disks):len(v.disks)], added...)
+	rs := reshapeState{
+		old:      v.oldView(v.r, all[:v.r.totalShards]),
+		stripes:  (size + newStripeSize - 1) / newStripeSize,
+		size:     size,
+		backward: dataShards < v.r.dataShards,
+	}
+	if rs.backward {
+		rs.position = rs.stripes
+	}
+
+	v.r = r
+	v.disks = all[:r.totalShards]
+	v.reshape = &rs
+	fmt.Printf("Reshape to %d+%d started: %d stripes \n", dataShards, parityShards, rs.stripes)
+	return v.setState(StateActive)
+}
+
+// ReshapeStep rewrites up to n stripes into the new geometry and reports
+// whether the reshape is complete. Reads and writes from other goroutines
+// wait for the step and run between steps.
+func (v *Volume) ReshapeStep(n int) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return false, errReadOnly
+	}
+	rs := v.reshape
+	if rs == nil {
+		return true, nil
+	}
+	for i := 0; i < n && !rs.done(); i++ {
+		err := v.reshapeStripe(rs.nextStripe())
+		if err != nil {
+			return false, err
+		}
+	}
+	if !rs.done() {
+		return false, nil
+	}
+	return true, v.finishReshape()
+}
+
+// Reshape runs a complete reshape to dataShards+parityShards.
+func (v *Volume) Reshape(dataShards, parityShards int, added []Disk) error {
+	err := v.StartReshape(dataShards, parityShards, added)
+	if err != nil {
+		return err
+	}
+	for {
+		done, err := v.ReshapeStep(defaultRegionStripes)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// ReshapeProgress returns the number of stripes rewritten so far and the
+// total, or ok == false if no reshape is running.
+func (v *Volume) ReshapeProgress() (done, total int64, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.reshapeProgress()
+}
+
+func (v *Volume) reshapeProgress() (done, total int64, ok bool) {
+	rs := v.reshape
+	if rs == nil {
+		return 0, 0, false
+	}
+	if rs.backward {
+		return rs.stripes - rs.position, rs.stripes, true
+	}
+	return rs.position, rs.stripes, true
+}
+
+// reshapeStripe gathers the logical data of new stripe s from the old
+// geometry and writes it in the new one.
+func (v *Volume) reshapeStripe(s int64) error {
+	rs := v.reshape
+	data := make([]byte, v.stripeSize())
+	start := s * int64(len(data))
+	end := start + int64(len(data))
+	if end > rs.size {
+		end = rs.size
+	}
+	if start < end {
+		err := rs.old.readLogical(data[:end-start], start)
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded, err := v.encodeStripe(data)
+	if err != nil {
+		return err
+	}
+	err = v.markDirty(s)
+	if err != nil {
+		return err
+	}
+	err = v.markIntent(s)
+	if err != nil {
+		return err
+	}
+	if v.journal == nil {
+		// Only the parity count changes and parity has its own disks, so
+		// the data chunks are rewritten in place with identical contents
+		// and a crash is harmless.
+		err = v.writeChunks(s, encoded)
+		if err != nil {
+			return err
+		}
+		return v.advanceReshape()
+	}
+
+	// The position is persisted before the record is completed: a replay
+	// either finds the record pending and advances, or finds it applied
+	// and the position already past it.
+	offset, err := v.journal.append(s, encoded)
+	if err != nil {
+		return err
+	}
+	err = v.writeChunks(s, encoded)
+	if err != nil {
+		return err
+	}
+	err = v.advanceReshape()
+	if err != nil {
+		return err
+	}
+	return v.journal.complete(offset)
+}
+
+// writeOldStripe writes a stripe that is still in the old geometry. The
+// old view has no superblock or journal of its own, so the dirty map,
+// the intent bitmap and the journal of v cover the write. Both geometries
+// keep stripe n at chunk position n, and the maps count chunk positions,
+// so the old stripe number selects the right region in either.
+func (v *Volume) writeOldStripe(stripe int64, data []byte) error {
+	old := v.reshape.old
+	encoded, err := old.encodeStripe(data)
+	if err != nil {
+		return err
+	}
+	err = v.markDirty(stripe)
+	if err != nil {
+		return err
+	}
+	err = v.markIntent(stripe)
+	if err != nil {
+		return err
+	}
+
+	if v.journal == nil {
+		err = old.writeChunks(stripe, encoded)
+	} else {
+		var offset int64
+		offset, err = v.journal.append(stripe|journalOldGeometry, encoded)
+		if err != nil {
+			return err
+		}
+		err = old.writeChunks(stripe, encoded)
+		if err == nil {
+			err = v.journal.complete(offset)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// A member that failed the write was dropped from the shared member
+	// slice; start tracking in v as well.
+	v.noteDegraded()
+	return v.markDirty(stripe)
+}
+
+// advanceReshape moves the reshape position past the stripe just written
+// and persists it.
+func (v *Volume) advanceReshape() error {
+	rs := v.reshape
+	if rs.backward {
+		rs.position--
+	} else {
+		rs.position++
+	}
+	return v.writeSuperblocks()
+}
+
+// finishReshape drops the old geometry and members no longer needed.
+// When the array got fewer stripes, the positions past the end still
+// hold old stripes; they are overwritten with zeros so that every
+// position verifies in the new geometry.
+func (v *Volume) finishReshape() error {
+	old := v.reshape.old
+	stripes := v.reshape.stripes
+	oldStripes, err := old.stripeCount()
+	if err != nil {
+		return err
+	}
+	zero, err := v.encodeStripe(nil)
+	if err != nil {
+		return err
+	}
+	for s := stripes; s < oldStripes; s++ {
+		err = v.writeChunks(s, zero)
+		if err != nil {
+			return err
+		}
+	}
+
+	v.reshape = nil
+	for i := len(v.disks); i < len(old.disks); i++ {
+		if old.disks[i] != nil {
+			old.disks[i].Close()
+		}
+	}
+	fmt.Printf("Reshape to %d+%d finished \n", v.r.dataShards, v.r.parityShards)
+	return v.setState(StateActive)
+}
+
+// resumeReshape restores an interrupted reshape from a superblock.
+// members holds every member of both geometries.
+func (v *Volume) resumeReshape(sb *superblock, members []Disk) error {
+	r, err := newRaid6(sb.reshapeFromData, sb.reshapeFromParity)
+	if err != nil {
+		return err
+	}
+	if len(members) < r.totalShards {
+		return errors.New("reshape superblock does not match members")
+	}
+	v.reshape = &reshapeState{
+		old:      v.oldView(r, members[:r.totalShards]),
+		position: sb.reshapePosition,
+		stripes:  sb.reshapeStripes,
+		size:     sb.reshapeSize,
+		backward: v.r.dataShards < r.dataShards,
+	}
+	fmt.Printf("Resuming reshape at stripe %d of %d \n", sb.reshapePosition, sb.reshapeStripes)
+	return nil
+}
+
+// readLogical fills p with logical data starting at off, stripe by stripe.
+func (v *Volume) readLogical(p []byte, off int64) error {
+	stripeSize := int64(v.stripeSize())
+	for len(p) > 0 {
+		stripe := off / stripeSize
+		within := off % stripeSize
+		data, err := v.readStripe(stripe)
+		if err != nil {
+			return err
+		}
+		n := copy(p, data[within:])
+		p = p[n:]
+		off += int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package raid6
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// TestReshapeConcurrentIO drives a reshape from one goroutine while
+// another reads and writes the array, which must stay race free and see
+// its own writes.
+func TestReshapeConcurrentIO(t *testing.T) {
+	v := journaledVolume(t, 4, 2, 64, memDisks(6), NewMemDisk())
+	rng := rand.New(rand.NewSource(1))
+	want := make([]byte, 64*v.StripeSize())
+	rng.Read(want)
+	if _, err := v.WriteAt(want, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.StartReshape(5, 2, []Disk{NewMemDisk()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			done, err := v.ReshapeStep(1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if done {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		off := rng.Intn(len(want) - 300)
+		p := make([]byte, 1+rng.Intn(300))
+		if i%2 == 0 {
+			rng.Read(p)
+			copy(want[off:], p)
+			if _, err := v.WriteAt(p, int64(off)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if _, err := v.ReadAt(p, int64(off)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, want[off:off+len(p)]) {
+			t.Fatalf("read at %d differs during reshape", off)
+		}
+	}
+	wg.Wait()
+
+	got := make([]byte, len(want))
+	if _, err := v.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("data differs after reshape")
+	}
+}
+
+// TestReshapeCrashRotatingLayout crashes a reshape that only adds a
+// parity shard to a rotating layout, where every data chunk moves to
+// another member, and requires the data to survive the journal replay
+// and the resumed reshape.
+func TestReshapeCrashRotatingLayout(t *testing.T) {
+	const trials = 50
+	for _, layout := range []Layout{LayoutLeftSymmetric, LayoutRightAsymmetric} {
+		t.Run(layout.String(), func(t *testing.T) {
+			v, err := createVolume(4, 2, 64, memDisks(6))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.SetLayout(layout); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.StartReshape(4, 3, memDisks(1)); err == nil {
+				t.Fatal("reshape of a rotating layout started without a journal")
+			}
+
+			rng := rand.New(rand.NewSource(int64(layout)))
+			names := []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6"}
+			for trial := 0; trial < trials; trial++ {
+				sw := &crashSwitch{budget: -1, rng: rng}
+				members, wrapped := crashDisks(7, sw)
+				journalDisk := NewMemDisk()
+				v, err := createVolume(4, 2, 64, wrapped[:6])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := v.SetLayout(layout); err != nil {
+					t.Fatal(err)
+				}
+				j, err := OpenJournal(&crashDisk{Disk: journalDisk, sw: sw}, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := v.UseJournal(j); err != nil {
+					t.Fatal(err)
+				}
+				want := make([]byte, 16*v.StripeSize())
+				rng.Read(want)
+				if _, err := v.WriteAt(want, 0); err != nil {
+					t.Fatal(err)
+				}
+
+				crashAt := rng.Intn(400)
+				sw.budget = crashAt
+				err = v.Reshape(4, 3, wrapped[6:])
+				if err != nil && !errors.Is(err, errCrashed) {
+					t.Fatal(err)
+				}
+
+				v, err = assembleDisks(names, members, false)
+				if err != nil {
+					t.Fatalf("trial %d: %v", trial, err)
+				}
+				j, err = OpenJournal(journalDisk, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := v.UseJournal(j); err != nil {
+					t.Fatal(err)
+				}
+				for done := false; !done; {
+					done, err = v.ReshapeStep(4)
+					if err != nil {
+						t.Fatalf("trial %d: %v", trial, err)
+					}
+				}
+				got := make([]byte, len(want))
+				if _, err := v.ReadAt(got, 0); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("trial %d: data differs after crash at write %d", trial, crashAt)
+				}
+			}
+		})
+	}
+}
+
+// TestReshapeCrashOldGeometryWrite crashes a write to a stripe that is
+// still in the old geometry and requires the journal replay to leave it
+// holding either the old or the new data, with consistent parity, once
+// the reshape has finished.
+func TestReshapeCrashOldGeometryWrite(t *testing.T) {
+	const trials = 50
+	rng := rand.New(rand.NewSource(1))
+	names := []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6"}
+	for trial := 0; trial < trials; trial++ {
+		sw := &crashSwitch{budget: -1, rng: rng}
+		members, wrapped := crashDisks(7, sw)
+		journalDisk := NewMemDisk()
+		v, err := createVolume(4, 2, 64, wrapped[:6])
+		if err != nil {
+			t.Fatal(err)
+		}
+		j, err := OpenJournal(&crashDisk{Disk: journalDisk, sw: sw}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.UseJournal(j); err != nil {
+			t.Fatal(err)
+		}
+		want := make([]byte, 16*v.StripeSize())
+		rng.Read(want)
+		if _, err := v.WriteAt(want, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.StartReshape(5, 2, wrapped[6:]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.ReshapeStep(2); err != nil {
+			t.Fatal(err)
+		}
+
+		// Old stripe 12 is far past the reshape position.
+		off := 12 * 256
+		if g, _, _ := v.locate(int64(off)); g == v {
+			t.Fatal("target stripe already reshaped")
+		}
+		update := make([]byte, 256)
+		rng.Read(update)
+		// One journal write, one write per member and the applied mark.
+		sw.budget = rng.Intn(6 + 2)
+		writeErr := error(nil)
+		if _, err := v.WriteAt(update, int64(off)); err != nil {
+			if !errors.Is(err, errCrashed) {
+				t.Fatal(err)
+			}
+			writeErr = err
+		}
+
+		v, err = assembleDisks(names, members, false)
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		j, err = OpenJournal(journalDisk, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.UseJournal(j); err != nil {
+			t.Fatal(err)
+		}
+		for done := false; !done; {
+			done, err = v.ReshapeStep(4)
+			if err != nil {
+				t.Fatalf("trial %d: %v", trial, err)
+			}
+		}
+		count, err := v.StripeCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkConsistent(t, v, int(count))
+
+		got := make([]byte, len(want))
+		if _, err := v.ReadAt(got, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got[:off], want[:off]) || !bytes.Equal(got[off+256:], want[off+256:]) {
+			t.Fatalf("trial %d: write to stripe 12 changed other data", trial)
+		}
+		target := got[off : off+256]
+		switch {
+		case bytes.Equal(target, update):
+		case bytes.Equal(target, want[off:off+256]) && writeErr != nil:
+		default:
+			t.Fatalf("trial %d: stripe 12 holds neither old nor new data", trial)
+		}
+	}
+}
+
+// TestReshapeDegradedDirty checks that stripes written by a reshape step
+// or into the old geometry while a member is missing reach the dirty map.
+func TestReshapeDegradedDirty(t *testing.T) {
+	v, err := createVolume(4, 2, 64, memDisks(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*defaultRegionStripes*v.StripeSize())
+	if _, err := v.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.StartReshape(4, 3, memDisks(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.FailMember(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.ReshapeStep(1); err != nil {
+		t.Fatal(err)
+	}
+	if v.dirty[0] != 1 {
+		t.Fatalf("dirty map %08b after a degraded reshape step, want region 0", v.dirty[0])
+	}
+	if _, err := v.WriteAt([]byte("old geometry"), int64(defaultRegionStripes+3)*256); err != nil {
+		t.Fatal(err)
+	}
+	if v.dirty[0] != 3 {
+		t.Fatalf("dirty map %08b after a degraded old geometry write, want regions 0 and 1", v.dirty[0])
+	}
+}
+
+func memDisks(n int) []Disk {
+	disks := make([]Disk, n)
+	for i := range disks {
+		disks[i] = NewMemDisk()
+	}
+	return disks
+}
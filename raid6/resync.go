@@ -0,0 +1,213 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+)
+
+// While a member is missing, the remaining members keep a dirty map in
+// their superblock with one bit per region of regionStripes stripes that
+// was written since the array became degraded. degradedEvents holds the
+// event counter at that moment.
+//
+// A member that returns with an event counter of at least degradedEvents
+// missed exactly the writes recorded in the dirty map, so only those
+// regions are resynced. A member that left earlier has diverged in an
+// unknown way and is treated as failed; it can only come back through
+// a full Rebuild.
+
+const defaultRegionStripes = 64
+
+// errStaleMember is returned if a member diverged before the dirty map was started.
+var errStaleMember = errors.New("member diverged before dirty tracking started, rebuild required")
+
+// regionOf returns the dirty map bit covering stripe. Stripes beyond the
+// map share the last bit, which then covers everything up to the end.
+func (v *Volume) regionOf(stripe int64) int {
+	region := stripe / int64(v.regionStripes)
+	if region >= dirtyMapBytes*8 {
+		return dirtyMapBytes*8 - 1
+	}
+	return int(region)
+}
+
+// noteDegraded starts dirty tracking when the array becomes degraded.
+func (v *Volume) noteDegraded() {
+	if v.degraded() && v.degradedEvents == 0 {
+		v.degradedEvents = v.events
+	}
+}
+
+// markDirty records that stripe diverges from the missing members. The
+// superblocks are only rewritten the first time a region gets dirty.
+func (v *Volume) markDirty(stripe int64) error {
+	if !v.degraded() {
+		return nil
+	}
+	region := v.regionOf(stripe)
+	if v.dirty[region/8]&(1<<(region%8)) != 0 {
+		return nil
+	}
+	v.dirty[region/8] |= 1 << (region % 8)
+	return v.writeSuperblocks()
+}
+
+// FailMember closes member i and continues without it. The member's
+// superblock is left behind, so it is recognized as stale if it returns.
+func (v *Volume) FailMember(i int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return errReadOnly
+	}
+	return v.failMember(i)
+}
+
+func (v *Volume) failMember(i int) error {
+	if i < 0 || i >= len(v.disks) {
+		return errors.New("invalid member index")
+	}
+	if v.disks[i] == nil {
+		return nil
+	}
+	v.disks[i].Close()
+	v.disks[i] = nil
+	if len(v.missing()) > v.r.parityShards {
+		return errors.New("too many failed members, array is offline")
+	}
+	v.noteDegraded()
+	return v.setState(v.state)
+}
+
+// StripeCount returns the number of stripes stored on the largest member.
+func (v *Volume) StripeCount() (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.stripeCount()
+}
+
+func (v *Volume) stripeCount() (int64, error) {
+	var count int64
+	for _, d := range v.disks {
+		if d == nil {
+			continue
+		}
+		size, err := diskSize(d)
+		if err != nil {
+			return 0, err
+		}
+		stripes := (size - v.dataOffset + int64(v.chunkSize) - 1) / int64(v.chunkSize)
+		if stripes > count {
+			count = stripes
+		}
+	}
+	return count, nil
+}
+
+// rebuildChunk reconstructs the chunk member i holds in stripe from the
+// present members and writes it to d.
+func (v *Volume) rebuildChunk(i int, stripe int64, d Disk) error {
+	r := v.r.codec(v.readChunks(stripe))
+	err := r.ReconstructDisk()
+	if err != nil {
+		return fmt.Errorf("stripe %d: %w", stripe, err)
+	}
+	_, err = d.WriteAt(r.DiskArray[v.shardOf(stripe, i)], v.chunkOffset(stripe))
+	return err
+}
+
+// readmit brings a stale member back into the array by resyncing only the
+// regions written while it was away.
+func (v *Volume) readmit(sb *superblock, d Disk) error {
+	if v.reshape != nil {
+		return errReshaping
+	}
+	if v.disks[sb.index] != nil {
+		return fmt.Errorf("member %d already present", sb.index)
+	}
+	if v.degradedEvents == 0 || sb.events < v.degradedEvents {
+		return errStaleMember
+	}
+
+	count, err := v.stripeCount()
+	if err != nil {
+		return err
+	}
+	regions := dirtyMapBytes * 8
+	resynced := 0
+	for region := 0; region < regions; region++ {
+		if v.dirty[region/8]&(1<<(region%8)) == 0 {
+			continue
+		}
+		start := int64(region) * int64(v.regionStripes)
+		end := start + int64(v.regionStripes)
+		if region == regions-1 || end > count {
+			end = count
+		}
+		for stripe := start; stripe < end; stripe++ {
+			err = v.rebuildChunk(sb.index, stripe, d)
+			if err != nil {
+				return err
+			}
+		}
+		resynced++
+	}
+	err = d.Sync()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Member %d resynced %d dirty regions \n", sb.index, resynced)
+
+	v.disks[sb.index] = d
+	v.clearDirty()
+	return v.setState(StateActive)
+}
+
+// Rebuild reconstructs every stripe of missing member i onto d and adds
+// it to the array. It is used for replacement disks and for members that
+// are too stale to be resynced.
+func (v *Volume) Rebuild(i int, d Disk) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return errReadOnly
+	}
+	if i < 0 || i >= len(v.disks) {
+		return errors.New("invalid member index")
+	}
+	if v.disks[i] != nil {
+		return fmt.Errorf("member %d is present", i)
+	}
+	if v.reshape != nil {
+		return errReshaping
+	}
+	count, err := v.stripeCount()
+	if err != nil {
+		return err
+	}
+	for stripe := int64(0); stripe < count; stripe++ {
+		err = v.rebuildChunk(i, stripe, d)
+		if err != nil {
+			return err
+		}
+	}
+	err = d.Sync()
+	if err != nil {
+		return err
+	}
+
+	v.disks[i] = d
+	v.clearDirty()
+	return v.setState(StateActive)
+}
+
+// clearDirty drops the dirty map once no member is missing any more.
+func (v *Volume) clearDirty() {
+	if v.degraded() {
+		return
+	}
+	v.degradedEvents = 0
+	for i := range v.dirty {
+		v.dirty[i] = 0
+	}
+}
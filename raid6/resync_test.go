@@ -0,0 +1,160 @@
+package raid6
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// TestReadmitDirtyRegions fails a member, writes to one region while it
+// is away and re-adds it. Only the dirty region may be resynced: a marker
+// planted in a clean region of the returning member must survive.
+func TestReadmitDirtyRegions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Four regions of 64 stripes of 256 bytes.
+	data := make([]byte, 4*defaultRegionStripes*256)
+	rng.Read(data)
+	paths := createArray(t, t.TempDir(), data)
+
+	v, err := Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.FailMember(3); err != nil {
+		t.Fatal(err)
+	}
+	if v.degradedEvents == 0 {
+		t.Fatal("failing a member did not start dirty tracking")
+	}
+	update := make([]byte, 256)
+	rng.Read(update)
+	dirtyStripe := int64(2*defaultRegionStripes + 5)
+	if _, err := v.WriteAt(update, dirtyStripe*256); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[dirtyStripe*256:], update)
+	if got := v.dirty[0]; got != 1<<2 {
+		t.Fatalf("dirty map %08b, want only region 2", got)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plant a marker in region 0 of the returning member.
+	marker := bytes.Repeat([]byte{0xa5}, 64)
+	markerOffset := int64(dataAreaOffset + 10*64)
+	f, err := os.OpenFile(paths[3], os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(marker, markerOffset); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	v, err = Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Degraded() {
+		t.Fatalf("member not readmitted: missing %v", v.Missing())
+	}
+	start := int64(2 * defaultRegionStripes)
+	for s := start; s < start+defaultRegionStripes; s++ {
+		valid, err := v.VerifyStripe(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !all(valid) {
+			t.Fatalf("stripe %d inconsistent after resync", s)
+		}
+	}
+	got := make([]byte, defaultRegionStripes*256)
+	if _, err := v.ReadAt(got, start*256); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[start*256:][:len(got)]) {
+		t.Fatal("dirty region holds wrong data after resync")
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	member, _ := os.ReadFile(paths[3])
+	if !bytes.Equal(member[markerOffset:markerOffset+64], marker) {
+		t.Fatal("clean region of the returning member was resynced")
+	}
+	sb := superblockOf(t, paths[0])
+	if sb.degradedEvents != 0 || sb.dirty[0] != 0 {
+		t.Fatal("dirty map not cleared after the member returned")
+	}
+}
+
+// TestReadmitStale checks that a member that left before the array was
+// degraded is refused by readmit and can only return through Rebuild.
+func TestReadmitStale(t *testing.T) {
+	paths := createArray(t, t.TempDir(), bytes.Repeat([]byte("old"), 500))
+	old, err := os.ReadFile(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("new"), 500)
+	if _, err := v.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths[1], old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenFileDisk(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := readSuperblock(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.readmit(sb, d); !errors.Is(err, errStaleMember) {
+		t.Fatalf("readmit of a stale member: %v", err)
+	}
+
+	if err := v.Rebuild(1, d); err != nil {
+		t.Fatal(err)
+	}
+	if v.Degraded() {
+		t.Fatalf("missing %v after rebuild", v.Missing())
+	}
+	count, err := v.StripeCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConsistent(t, v, int(count))
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Degraded() {
+		t.Fatalf("rebuilt member rejected: missing %v", v.Missing())
+	}
+	checkRead(t, v, data)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
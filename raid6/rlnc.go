@@ -0,0 +1,204 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"raid6/raid6/gf256"
+)
+
+// Random linear network coding sends packets that are random GF(2^8)
+// combinations of a generation of k source packets. Every coded packet
+// carries its coefficient vector, so a receiver needs no shared state
+// and any k packets with independent vectors decode the generation.
+// Intermediate nodes recode: they send random combinations of the coded
+// packets they hold, whose vectors they compute from the held vectors,
+// without decoding first.
+
+// RLNCPacket is a coded packet: Data is the combination of the source
+// packets with Coefficients.
+type RLNCPacket struct {
+	Coefficients []byte
+	Data         []byte
+}
+
+// RLNCEncoder emits coded packets of a generation of source packets.
+type RLNCEncoder struct {
+	source Matrix
+	rng    *rng
+}
+
+// errRLNCPacket is returned for packets that do not fit the generation.
+var errRLNCPacket = errors.New("packet does not match the generation size")
+
+// NewRLNCEncoder returns an encoder for the source packets, which must
+// have the same length. The seed only picks coefficients.
+func NewRLNCEncoder(source [][]byte, seed int64) (*RLNCEncoder, error) {
+	if len(source) == 0 || len(source[0]) == 0 {
+		return nil, errRLNCPacket
+	}
+	for _, p := range source {
+		if len(p) != len(source[0]) {
+			return nil, errRLNCPacket
+		}
+	}
+	err := checkField()
+	if err != nil {
+		return nil, err
+	}
+	return &RLNCEncoder{source: source, rng: splitMix(uint64(seed))}, nil
+}
+
+// randomVector returns n random coefficients, not all zero.
+func (r *rng) randomVector(n int) []byte {
+	v := make([]byte, n)
+	for {
+		zero := true
+		for i := range v {
+			v[i] = byte(r.next())
+			zero = zero && v[i] == 0
+		}
+		if !zero {
+			return v
+		}
+	}
+}
+
+// Packet returns a new coded packet with random coefficients.
+func (e *RLNCEncoder) Packet() RLNCPacket {
+	coefs := e.rng.randomVector(len(e.source))
+	data := make([]byte, len(e.source[0]))
+	for i, c := range coefs {
+		gf256.MulAddSlice(c, e.source[i], data)
+	}
+	return RLNCPacket{Coefficients: coefs, Data: data}
+}
+
+// RLNCDecoder decodes a generation progressively. Every packet is
+// reduced against the rows held so far as it arrives, keeping them in
+// reduced row echelon form, so the rank is always known and a source
+// packet is released as soon as its row has no other coefficient left.
+type RLNCDecoder struct {
+	k, size int
+	rows    Matrix // coefficients followed by data
+	pivots  []int
+	decoded []bool
+	rng     *rng
+}
+
+// NewRLNCDecoder returns a decoder for a generation of k packets of size
+// bytes. The seed picks the coefficients of recoded packets.
+func NewRLNCDecoder(k, size int, seed int64) (*RLNCDecoder, error) {
+	if k <= 0 || size <= 0 {
+		return nil, errRLNCPacket
+	}
+	err := checkField()
+	if err != nil {
+		return nil, err
+	}
+	return &RLNCDecoder{k: k, size: size, decoded: make([]bool, k), rng: splitMix(uint64(seed))}, nil
+}
+
+// Rank returns the number of independent packets received.
+func (d *RLNCDecoder) Rank() int {
+	return len(d.rows)
+}
+
+// Done reports whether the whole generation is decoded.
+func (d *RLNCDecoder) Done() bool {
+	return len(d.rows) == d.k
+}
+
+// Add reduces a packet into the decoder and returns the source packets
+// it made decodable, in increasing order. A packet that does not raise
+// the rank is dropped and returns none.
+func (d *RLNCDecoder) Add(p RLNCPacket) ([]int, error) {
+	if len(p.Coefficients) != d.k || len(p.Data) != d.size {
+		return nil, errRLNCPacket
+	}
+	row := append(append([]byte(nil), p.Coefficients...), p.Data...)
+	for i, pivot := range d.pivots {
+		if c := row[pivot]; c != 0 {
+			gf256.MulAddSlice(c, d.rows[i], row)
+		}
+	}
+	pivot := -1
+	for c := 0; c < d.k; c++ {
+		if row[c] != 0 {
+			pivot = c
+			break
+		}
+	}
+	if pivot < 0 {
+		return nil, nil
+	}
+	gf256.MulSlice(gf256.Inv(row[pivot]), row, row)
+	for i := range d.rows {
+		if c := d.rows[i][pivot]; c != 0 {
+			gf256.MulAddSlice(c, row, d.rows[i])
+		}
+	}
+	d.rows = append(d.rows, row)
+	d.pivots = append(d.pivots, pivot)
+
+	var released []int
+	for i, pivot := range d.pivots {
+		if !d.decoded[pivot] && d.unit(i) {
+			d.decoded[pivot] = true
+			released = append(released, pivot)
+		}
+	}
+	sort.Ints(released)
+	return released, nil
+}
+
+// unit reports whether row i has no coefficient besides its pivot.
+func (d *RLNCDecoder) unit(i int) bool {
+	for c, v := range d.rows[i][:d.k] {
+		if v != 0 && c != d.pivots[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Decoded returns source packet i if it is decodable yet.
+func (d *RLNCDecoder) Decoded(i int) ([]byte, bool) {
+	if i < 0 || i >= d.k || !d.decoded[i] {
+		return nil, false
+	}
+	for r, pivot := range d.pivots {
+		if pivot == i {
+			return d.rows[r][d.k:], true
+		}
+	}
+	return nil, false
+}
+
+// Source returns the decoded generation.
+func (d *RLNCDecoder) Source() ([][]byte, error) {
+	if !d.Done() {
+		return nil, fmt.Errorf("rank %d of %d, generation not decoded", len(d.rows), d.k)
+	}
+	source := make([][]byte, d.k)
+	for i := range source {
+		source[i], _ = d.Decoded(i)
+	}
+	return source, nil
+}
+
+// Recode returns a random combination of the packets held, as an
+// intermediate node forwards without decoding. Its coefficients are
+// relative to the source packets, so receivers cannot tell it from an
+// encoded packet.
+func (d *RLNCDecoder) Recode() (RLNCPacket, error) {
+	if len(d.rows) == 0 {
+		return RLNCPacket{}, errors.New("nothing received to recode")
+	}
+	row := make([]byte, d.k+d.size)
+	for i, c := range d.rng.randomVector(len(d.rows)) {
+		gf256.MulAddSlice(c, d.rows[i], row)
+	}
+	return RLNCPacket{Coefficients: row[:d.k], Data: row[d.k:]}, nil
+}
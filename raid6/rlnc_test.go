@@ -0,0 +1,119 @@
+package raid6
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestRLNCDecode(t *testing.T) {
+	const k = 16
+	source := randomShards(rand.New(rand.NewSource(1)), k, 48)
+	e, err := NewRLNCEncoder(source, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewRLNCDecoder(k, 48, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Systematic packets are released as they arrive.
+	unit := make([]byte, k)
+	unit[5] = 1
+	released, err := d.Add(RLNCPacket{Coefficients: unit, Data: source[5]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(released) != "[5]" {
+		t.Fatalf("released %v, want [5]", released)
+	}
+	if got, ok := d.Decoded(5); !ok || string(got) != string(source[5]) {
+		t.Fatal("packet 5 not decoded")
+	}
+	if _, err := d.Add(RLNCPacket{Coefficients: unit[:k-1], Data: source[5]}); err == nil {
+		t.Fatal("short coefficient vector accepted")
+	}
+
+	for sent := 0; !d.Done(); sent++ {
+		if sent > 2*k {
+			t.Fatalf("rank %d after %d packets", d.Rank(), sent)
+		}
+		rank := d.Rank()
+		if _, err := d.Add(e.Packet()); err != nil {
+			t.Fatal(err)
+		}
+		if d.Rank() < rank || d.Rank() > rank+1 {
+			t.Fatalf("rank went from %d to %d", rank, d.Rank())
+		}
+	}
+	got, err := d.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShards(t, got, source)
+}
+
+func TestRLNCRecode(t *testing.T) {
+	const k = 8
+	source := randomShards(rand.New(rand.NewSource(2)), k, 32)
+	e, err := NewRLNCEncoder(source, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := NewRLNCDecoder(k, 32, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.Recode(); err == nil {
+		t.Fatal("empty relay recoded")
+	}
+
+	// The relay holds only part of the generation, so what it forwards
+	// spans at most its own rank.
+	for relay.Rank() < k/2 {
+		if _, err := relay.Add(e.Packet()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink, err := NewRLNCDecoder(k, 32, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*k; i++ {
+		p, err := relay.Recode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sink.Rank() != k/2 {
+		t.Fatalf("sink rank %d from a relay of rank %d", sink.Rank(), k/2)
+	}
+
+	// Once the relay is complete its recoded packets decode everything.
+	for !relay.Done() {
+		if _, err := relay.Add(e.Packet()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; !sink.Done(); i++ {
+		if i > 2*k {
+			t.Fatalf("sink rank %d", sink.Rank())
+		}
+		p, err := relay.Recode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := sink.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkShards(t, got, source)
+}
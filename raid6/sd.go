@@ -0,0 +1,332 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+
+	"raid6/raid6/gf256"
+)
+
+// SDCode is a sector-disk code. A stripe is rows sectors on each of
+// disks disks. The last parityDisks disks hold parity as usual, and the
+// data disks additionally reserve sectorParity sectors of the stripe,
+// the last ones of its last row, for parity over the whole stripe. The
+// stripe then survives parityDisks failed disks plus sectorParity
+// unreadable sectors anywhere else, the case of a rebuild hitting a
+// latent sector error, at the cost of a few sectors instead of a disk.
+//
+// The code is defined by its parity-check matrix. Sector x = row*disks +
+// disk gets the element g_x = 2^x, and the stripe satisfies
+//
+//	sum over the disks of g_x^j * sector_x = 0        each row, j < parityDisks
+//	sum over the stripe of g_x^e_l * sector_x = 0     l < sectorParity
+//
+// The disk equations are Vandermonde in every row, so any parityDisks
+// failures of a row decode. Whether the sector equations add any
+// sectorParity sectors depends on the exponents e_l; NewSDCode tries
+// e_l = parityDisks+l and then random exponents, checking every pattern
+// for each, so a returned code tolerates all of them. Decoding solves
+// the equations of both kinds together for the erased sectors; encoding
+// is decoding with the parity sectors erased.
+type SDCode struct {
+	disks        int
+	parityDisks  int
+	rows         int
+	sectorParity int
+
+	check  Matrix
+	erased map[int]bool
+
+	DiskArray Matrix
+}
+
+// NewSDCode returns a sector-disk code. disks*rows must stay below 255.
+//
+// Only sectorParity 1 is practical: a single sector of parity works for
+// any such geometry. Two or more sectors only exist in GF(2^8) for toy
+// stripes, e.g. 6 disks of up to 9 rows or 8 disks of 3 rows with two
+// parity disks and two sectors. Realistic geometries such as
+// (20, 2, 12, 2), (12, 3, 10, 2) or (10, 2, 12, 3) return errNoSDCode;
+// they would need a wider field, since every failure pattern needs its
+// surviving sectors to give pairwise independent coefficient vectors.
+func NewSDCode(disks, parityDisks, rows, sectorParity int) (*SDCode, error) {
+	if parityDisks <= 0 || sectorParity < 0 || rows <= 0 || disks <= parityDisks {
+		return nil, errors.New("invalid disk, parity, row or sector parity count")
+	}
+	if disks*rows >= fieldSize {
+		return nil, errors.New("too many sectors per stripe for an 8-bit field")
+	}
+	if sectorParity >= (disks-parityDisks)*rows {
+		return nil, errors.New("sector parity leaves no data")
+	}
+	patterns := new(big.Int).Binomial(int64((disks-parityDisks)*rows), int64(sectorParity))
+	if patterns.Cmp(big.NewInt(sdPatternLimit)) > 0 {
+		return nil, errors.New("too many sector patterns to verify the code")
+	}
+	err := checkField()
+	if err != nil {
+		return nil, err
+	}
+	c := &SDCode{
+		disks:        disks,
+		parityDisks:  parityDisks,
+		rows:         rows,
+		sectorParity: sectorParity,
+		erased:       make(map[int]bool),
+	}
+	// Try the natural exponents first, then random ones, until every
+	// pattern decodes.
+	rng := rand.New(rand.NewSource(1))
+	exponents := make([]int, sectorParity)
+	for l := range exponents {
+		exponents[l] = parityDisks + l
+	}
+	for try := 0; try < sdSearchTries; try++ {
+		c.buildCheck(exponents)
+		if c.tolerates() {
+			return c, nil
+		}
+		for l := range exponents {
+			exponents[l] = 1 + rng.Intn(fieldSize-2)
+		}
+	}
+	return nil, errNoSDCode
+}
+
+// errNoSDCode is returned if no exponents were found. Every pattern must
+// give a non-singular system, and in an 8-bit field that only works out
+// for small stripes when sectorParity > 1; sectorParity 1 always does.
+var errNoSDCode = errors.New("no sector-disk code in GF(2^8) for this geometry")
+
+// sdSearchTries bounds the coefficient search of NewSDCode.
+const sdSearchTries = 200
+
+// buildCheck fills the parity-check matrix, with sector equation l
+// weighing sector x by 2^(exponents[l]*x).
+func (c *SDCode) buildCheck(exponents []int) {
+	c.check, _ = NewMatrix(c.parityDisks*c.rows+c.sectorParity, c.disks*c.rows)
+	for x := 0; x < c.disks*c.rows; x++ {
+		row := x / c.disks
+		for j := 0; j < c.parityDisks; j++ {
+			c.check[j*c.rows+row][x] = gf256.Exp(j * x)
+		}
+		for l, e := range exponents {
+			c.check[c.parityDisks*c.rows+l][x] = gf256.Exp(e * x)
+		}
+	}
+}
+
+// sdPatternLimit caps the sector patterns per set of failed disks that
+// tolerates checks, all of them, for every candidate code.
+const sdPatternLimit = 1 << 24
+
+// tolerates reports whether every pattern of parityDisks failed disks
+// plus sectorParity erased sectors decodes. Each row's disk equations
+// solve the failed disks' sectors in terms of the row's other sectors,
+// so substituting them leaves sector x with a vector u_x of coefficients
+// in the sector equations, and a pattern decodes exactly if the u_x of
+// its sectors are independent. Fewer failures are sub-patterns of these.
+// Every pattern is checked, which NewSDCode bounds by sdPatternLimit; a
+// failing set of exponents usually shows after a few patterns.
+func (c *SDCode) tolerates() bool {
+	m, s := c.parityDisks, c.sectorParity
+	if s == 0 {
+		return true
+	}
+	all := AnalysisOptions{Limit: sdPatternLimit}
+	global := c.check[m*c.rows:]
+	ok := true
+	subsets(c.disks, m, all, nil, func(failed []int) bool {
+		var vectors Matrix
+		for row := 0; row < c.rows; row++ {
+			// disk equations restricted to the failed disks of this row
+			lost, _ := NewMatrix(m, m)
+			for j := 0; j < m; j++ {
+				for i, d := range failed {
+					lost[j][i] = c.check[j*c.rows+row][row*c.disks+d]
+				}
+			}
+			inverse, err := lost.Invert()
+			if err != nil {
+				ok = false
+				return false
+			}
+			for d := 0; d < c.disks; d++ {
+				if containsInt(failed, d) {
+					continue
+				}
+				x := row*c.disks + d
+				u := make([]byte, s)
+				for l := range u {
+					u[l] = global[l][x]
+					// y_failed = inverse * column of x in the disk equations
+					for i, f := range failed {
+						var y byte
+						for j := 0; j < m; j++ {
+							y ^= galMultiply(inverse[i][j], c.check[j*c.rows+row][x])
+						}
+						u[l] ^= galMultiply(global[l][row*c.disks+f], y)
+					}
+				}
+				vectors = append(vectors, u)
+			}
+		}
+		subsets(len(vectors), s, all, nil, func(sectors []int) bool {
+			sub := make(Matrix, s)
+			for i, v := range sectors {
+				sub[i] = vectors[v]
+			}
+			if det, _ := sub.Determinant(); det == 0 {
+				ok = false
+			}
+			return ok
+		})
+		return ok
+	})
+	return ok
+}
+
+func containsInt(a []int, v int) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// IsParity reports whether the sector of disk in row holds parity.
+func (c *SDCode) IsParity(disk, row int) bool {
+	if disk >= c.disks-c.parityDisks {
+		return true
+	}
+	// Reserved sectors count back from the last data sector.
+	last := (c.rows-1)*(c.disks-c.parityDisks) + c.disks - c.parityDisks - 1
+	return last-(row*(c.disks-c.parityDisks)+disk) < c.sectorParity
+}
+
+// DataSectors returns the number of data sectors in a stripe.
+func (c *SDCode) DataSectors() int {
+	return (c.disks-c.parityDisks)*c.rows - c.sectorParity
+}
+
+// sector returns sector x of the disk array.
+func (c *SDCode) sector(x int) []byte {
+	shard := c.DiskArray[x%c.disks]
+	size := len(shard) / c.rows
+	row := x / c.disks
+	return shard[row*size : (row+1)*size]
+}
+
+// Encode computes parity for the data shards, one per data disk of rows
+// sectors each, and stores all shards in DiskArray. The reserved sectors
+// of the data shards are overwritten with sector parity.
+func (c *SDCode) Encode(shards [][]byte) error {
+	if len(shards) != c.disks-c.parityDisks {
+		return fmt.Errorf("need %d data shards, got %d", c.disks-c.parityDisks, len(shards))
+	}
+	size := len(shards[0])
+	for _, shard := range shards {
+		if len(shard) != size || size == 0 || size%c.rows != 0 {
+			return fmt.Errorf("shards must have equal length, a multiple of %d sectors", c.rows)
+		}
+	}
+	c.DiskArray = make(Matrix, c.disks)
+	for d := range c.DiskArray {
+		if d < len(shards) {
+			c.DiskArray[d] = append([]byte(nil), shards[d]...)
+		} else {
+			c.DiskArray[d] = make([]byte, size)
+		}
+	}
+	c.erased = make(map[int]bool)
+	for x := 0; x < c.disks*c.rows; x++ {
+		if c.IsParity(x%c.disks, x/c.disks) {
+			c.erased[x] = true
+		}
+	}
+	return c.Reconstruct()
+}
+
+// DropShard erases a whole disk.
+func (c *SDCode) DropShard(disk int) error {
+	if disk < 0 || disk >= len(c.DiskArray) {
+		return errors.New("invalid shard number")
+	}
+	c.DiskArray[disk] = nil
+	return nil
+}
+
+// EraseSector marks the sector of disk in row unreadable.
+func (c *SDCode) EraseSector(disk, row int) error {
+	if disk < 0 || disk >= c.disks || row < 0 || row >= c.rows {
+		return errors.New("invalid disk or row number")
+	}
+	c.erased[row*c.disks+disk] = true
+	return nil
+}
+
+// Reconstruct rebuilds the dropped disks and erased sectors by solving
+// the disk and sector equations for them together.
+func (c *SDCode) Reconstruct() error {
+	if len(c.DiskArray) != c.disks {
+		return errors.New("invalid disk array")
+	}
+	size := -1
+	for _, shard := range c.DiskArray {
+		if shard != nil {
+			size = len(shard)
+		}
+	}
+	if size < 0 {
+		return errTooManyErasures
+	}
+	var unknown []int
+	for x := 0; x < c.disks*c.rows; x++ {
+		if c.erased[x] || c.DiskArray[x%c.disks] == nil {
+			unknown = append(unknown, x)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	if len(unknown) > len(c.check) {
+		return errTooManyErasures
+	}
+
+	// check[:, unknown] * unknown = -check[:, known] * known, and minus
+	// is plus in the field.
+	system, _ := NewMatrix(len(c.check), len(unknown))
+	rhs, _ := NewMatrix(len(c.check), size/c.rows)
+	isUnknown := make(map[int]bool, len(unknown))
+	for j, x := range unknown {
+		isUnknown[x] = true
+		for i := range c.check {
+			system[i][j] = c.check[i][x]
+		}
+	}
+	for i, eq := range c.check {
+		for x, coef := range eq {
+			if coef != 0 && !isUnknown[x] {
+				gf256.MulAddSlice(coef, c.sector(x), rhs[i])
+			}
+		}
+	}
+	solution, err := SolveBlocks(system, rhs)
+	if err != nil {
+		return errTooManyErasures
+	}
+
+	for d, shard := range c.DiskArray {
+		if shard == nil {
+			c.DiskArray[d] = make([]byte, size)
+		}
+	}
+	for j, x := range unknown {
+		copy(c.sector(x), solution[j])
+	}
+	c.erased = make(map[int]bool)
+	return nil
+}
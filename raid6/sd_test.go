@@ -0,0 +1,72 @@
+package raid6
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestSDCodeReconstruct(t *testing.T) {
+	const disks, m, rows, s = 6, 2, 4, 2
+	c, err := NewSDCode(disks, m, rows, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	if err := c.Encode(randomShards(rng, disks-m, rows*16)); err != nil {
+		t.Fatal(err)
+	}
+	want := append(Matrix(nil), c.DiskArray...)
+
+	// Every pair of failed disks plus every pair of bad sectors on the
+	// survivors.
+	subsets(disks, m, AnalysisOptions{Limit: 1000}, rng, func(failed []int) bool {
+		subsets(disks*rows, s, AnalysisOptions{Limit: 1000}, rng, func(sectors []int) bool {
+			c.DiskArray = append(Matrix(nil), want...)
+			for _, d := range failed {
+				if err := c.DropShard(d); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, x := range sectors {
+				if err := c.EraseSector(x%disks, x/disks); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.Reconstruct(); err != nil {
+				t.Fatalf("disks %v, sectors %v: %v", failed, sectors, err)
+			}
+			checkShards(t, c.DiskArray, want)
+			return true
+		})
+		return true
+	})
+}
+
+func TestSDCodeGeometries(t *testing.T) {
+	for _, g := range [][4]int{{8, 2, 8, 1}, {16, 2, 15, 1}, {8, 2, 3, 2}, {4, 1, 4, 3}} {
+		if _, err := NewSDCode(g[0], g[1], g[2], g[3]); err != nil {
+			t.Errorf("%v: %v", g, err)
+		}
+	}
+	for _, g := range [][4]int{{8, 2, 8, 2}, {16, 2, 15, 2}, {16, 2, 16, 1}, {4, 2, 4, 8}} {
+		if _, err := NewSDCode(g[0], g[1], g[2], g[3]); err == nil {
+			t.Errorf("%v: built a code", g)
+		}
+	}
+}
+
+// TestSDCodeRealistic pins down the documented limit: realistic stripes
+// get a code with one sector of parity and none with more.
+func TestSDCodeRealistic(t *testing.T) {
+	for _, g := range [][3]int{{20, 2, 12}, {12, 3, 10}, {10, 2, 12}} {
+		if _, err := NewSDCode(g[0], g[1], g[2], 1); err != nil {
+			t.Errorf("%v with one sector: %v", g, err)
+		}
+	}
+	for _, g := range [][4]int{{20, 2, 12, 2}, {12, 3, 10, 2}, {10, 2, 12, 3}} {
+		if _, err := NewSDCode(g[0], g[1], g[2], g[3]); !errors.Is(err, errNoSDCode) {
+			t.Errorf("%v: %v, want errNoSDCode", g, err)
+		}
+	}
+}
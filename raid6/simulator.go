@@ -0,0 +1,373 @@
+package raid6
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The simulator is a small shell over the in-memory codec: it builds a
+// disk array, writes a string, injects erasures and bit flips and runs
+// the reconstruction methods, printing DiskArray after every step. It
+// reads commands interactively or from a scenario script, one per line:
+//
+//	build 5 5
+//	write "Lorem ipsum dolor sit amet"
+//	drop 3
+//	flip 6 1 bit=3
+//	verify
+//	reconstruct
+//	show hex
+//	undo
+//
+// Lines starting with # are comments.
+
+const simulatorHelp = `Commands:
+  build K M            build an array of K data and M parity shards
+  write "TEXT"         split TEXT over the data shards and encode parity
+  drop N...            erase shards
+  flip N BYTE [bit=B]  flip bit B (default 0) of byte BYTE in shard N
+  verify               check parity shards against the data shards
+  reconstruct          recover erased shards, or repair parity after bit flips
+  read                 print the data shards as text
+  show hex|ascii       choose how shards are printed
+  undo                 revert the last change
+  help                 print this help
+  quit                 leave the simulator
+`
+
+// errNoArray is returned by commands that need an array before build.
+var errNoArray = errors.New("no array, use build first")
+
+// errNoData is returned by commands that need data before write.
+var errNoData = errors.New("no data, use write first")
+
+// simState is one step of the simulator history.
+type simState struct {
+	r      *raid6
+	length int
+}
+
+type simulator struct {
+	r       *raid6
+	length  int
+	hex     bool
+	history []simState
+}
+
+// RunSimulator executes simulator commands from in. In interactive mode a
+// prompt is shown and a failing command only prints its error; in script
+// mode every command is echoed and the first failure stops the script.
+func RunSimulator(in io.Reader, interactive bool) error {
+	s := &simulator{}
+	scanner := bufio.NewScanner(in)
+	line := 0
+	for {
+		if interactive {
+			fmt.Print("raid6> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if !interactive {
+			fmt.Printf("> %s \n", text)
+		}
+
+		args, err := splitCommand(text)
+		if err == nil && (args[0] == "quit" || args[0] == "exit") {
+			return nil
+		}
+		if err == nil {
+			err = s.run(args)
+		}
+		if err != nil {
+			if !interactive {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			fmt.Printf("Error: %s \n", err)
+		}
+	}
+	if interactive {
+		fmt.Println()
+	}
+	return scanner.Err()
+}
+
+// splitCommand splits a command line into words. Double quoted words may
+// contain spaces and Go escape sequences.
+func splitCommand(text string) ([]string, error) {
+	var args []string
+	for text != "" {
+		if text[0] == '"' {
+			end := 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return nil, errors.New("unterminated string")
+			}
+			word, err := strconv.Unquote(text[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, word)
+			text = strings.TrimSpace(text[end+1:])
+			continue
+		}
+		end := strings.IndexAny(text, " \t")
+		if end < 0 {
+			end = len(text)
+		}
+		args = append(args, text[:end])
+		text = strings.TrimSpace(text[end:])
+	}
+	return args, nil
+}
+
+// atoi parses the numeric arguments of a command.
+func atoi(args []string) ([]int, error) {
+	values := make([]int, len(args))
+	for i, arg := range args {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", arg)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// save records the current state so the next change can be undone.
+func (s *simulator) save() {
+	state := simState{length: s.length}
+	if s.r != nil {
+		r := *s.r
+		r.DiskArray = make(Matrix, len(s.r.DiskArray))
+		for i, row := range s.r.DiskArray {
+			if row != nil {
+				r.DiskArray[i] = append([]byte(nil), row...)
+			}
+		}
+		state.r = &r
+	}
+	s.history = append(s.history, state)
+}
+
+// needData returns an error unless data has been written.
+func (s *simulator) needData() error {
+	if s.r == nil {
+		return errNoArray
+	}
+	if s.length == 0 {
+		return errNoData
+	}
+	return nil
+}
+
+func (s *simulator) run(args []string) error {
+	switch args[0] {
+	case "help":
+		fmt.Print(simulatorHelp)
+		return nil
+
+	case "build":
+		n, err := atoi(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(n) != 2 {
+			return errors.New("usage: build K M")
+		}
+		r, err := newRaid6(n[0], n[1])
+		if err != nil {
+			return err
+		}
+		r.DiskArray = make(Matrix, r.totalShards)
+		s.save()
+		s.r = r
+		s.length = 0
+		fmt.Printf("Built array with %d data and %d parity shards \n\n", n[0], n[1])
+		return nil
+
+	case "write":
+		if s.r == nil {
+			return errNoArray
+		}
+		if len(args) != 2 || args[1] == "" {
+			return errors.New(`usage: write "TEXT"`)
+		}
+		s.save()
+		shards, length := s.r.Split(args[1])
+		s.r.Encode(shards)
+		s.length = length
+		s.render("Clean Disk Array")
+		return nil
+
+	case "drop":
+		if err := s.needData(); err != nil {
+			return err
+		}
+		n, err := atoi(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(n) == 0 {
+			return errors.New("usage: drop N...")
+		}
+		for _, i := range n {
+			if i < 0 || i >= s.r.totalShards {
+				return fmt.Errorf("invalid shard number %d", i)
+			}
+		}
+		s.save()
+		for _, i := range n {
+			s.r.DropShard(i)
+		}
+		s.render("Erasure Disk Array")
+		return nil
+
+	case "flip":
+		if err := s.needData(); err != nil {
+			return err
+		}
+		bit := 0
+		if len(args) == 4 && strings.HasPrefix(args[3], "bit=") {
+			b, err := atoi([]string{strings.TrimPrefix(args[3], "bit=")})
+			if err != nil {
+				return err
+			}
+			bit = b[0]
+			args = args[:3]
+		}
+		n, err := atoi(args[1:])
+		if err != nil {
+			return err
+		}
+		if len(n) != 2 {
+			return errors.New("usage: flip N BYTE [bit=B]")
+		}
+		s.save()
+		err = s.r.FlipBit(n[0], n[1], bit)
+		if err != nil {
+			s.history = s.history[:len(s.history)-1]
+			return err
+		}
+		s.render("Corrupt Disk Array")
+		return nil
+
+	case "verify":
+		if err := s.needData(); err != nil {
+			return err
+		}
+		return s.verify()
+
+	case "reconstruct":
+		if err := s.needData(); err != nil {
+			return err
+		}
+		s.save()
+		erased := false
+		for _, ok := range s.r.DetectBrokenDisk() {
+			if !ok {
+				erased = true
+			}
+		}
+		// ReconstructDisk returns early when nothing is erased, so bit
+		// flips are handled by recomputing parity from the data.
+		var err error
+		if erased {
+			err = s.r.ReconstructDisk()
+		} else {
+			err = s.r.ReconstructCorruption()
+		}
+		s.render("Reconstructed Disk Array")
+		return err
+
+	case "read":
+		if err := s.needData(); err != nil {
+			return err
+		}
+		fmt.Printf("Output: \n%s \n\n", s.r.Join(s.r.DiskArray[:s.r.dataShards], s.length))
+		return nil
+
+	case "show":
+		if len(args) == 2 && args[1] == "hex" {
+			s.hex = true
+		} else if len(args) == 2 && args[1] == "ascii" {
+			s.hex = false
+		} else if len(args) != 1 {
+			return errors.New("usage: show hex|ascii")
+		}
+		if s.r == nil {
+			return nil
+		}
+		s.render("Disk Array")
+		return nil
+
+	case "undo":
+		if len(s.history) == 0 {
+			return errors.New("nothing to undo")
+		}
+		last := s.history[len(s.history)-1]
+		s.history = s.history[:len(s.history)-1]
+		s.r, s.length = last.r, last.length
+		if s.r != nil {
+			s.render("Disk Array")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command %q, try help", args[0])
+}
+
+// verify reports erased shards and checks every parity shard against the
+// data. Verify needs every data shard, so erased data is reported instead.
+func (s *simulator) verify() error {
+	valid := s.r.DetectBrokenDisk()
+	dataErased := false
+	for i, ok := range valid {
+		if !ok {
+			fmt.Printf("Disk %d: erased \n", i)
+			dataErased = dataErased || i < s.r.dataShards
+		}
+	}
+	if dataErased {
+		fmt.Println("Data shards erased, parity cannot be checked")
+		fmt.Println()
+		return nil
+	}
+
+	parity, _ := s.r.Verify()
+	for j, ok := range parity {
+		i := s.r.dataShards + j
+		if s.r.DiskArray[i] == nil {
+			continue
+		}
+		if ok {
+			fmt.Printf("Disk %d: parity ok \n", i)
+		} else {
+			fmt.Printf("Disk %d: parity mismatch \n", i)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+// render prints the disk array in the selected format.
+func (s *simulator) render(name string) {
+	if s.hex {
+		PrintDiskHex(name, s.r.DiskArray)
+	} else {
+		s.r.PrintDiskString(name, s.r.DiskArray)
+	}
+}
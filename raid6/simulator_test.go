@@ -0,0 +1,78 @@
+package raid6
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// captureStdout returns what f prints to standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- data
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+// TestSimulatorScript runs the scenario in testdata/simulator.txt, which
+// erases, reconstructs, flips a bit and undoes it, and compares the
+// output with testdata/simulator.golden. Run with -update to rewrite it.
+func TestSimulatorScript(t *testing.T) {
+	script, err := os.ReadFile(filepath.Join("testdata", "simulator.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runErr error
+	got := captureStdout(t, func() {
+		runErr = RunSimulator(bytes.NewReader(script), false)
+	})
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+
+	golden := filepath.Join("testdata", "simulator.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Fatalf("simulator output differs from %s:\n%s", golden, got)
+	}
+}
+
+// TestSimulatorScriptError checks that a script stops at the first failing
+// command and reports its line.
+func TestSimulatorScriptError(t *testing.T) {
+	var err error
+	captureStdout(t, func() {
+		err = RunSimulator(strings.NewReader("build 2 1\n# comment\nundo\nundo\nread\n"), false)
+	})
+	if err == nil || err.Error() != "line 4: nothing to undo" {
+		t.Fatalf("got %v, want an undo error on line 4", err)
+	}
+}
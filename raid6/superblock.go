@@ -0,0 +1,472 @@
+package raid6
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// Every file-backed member starts with a superblock describing the array
+// it belongs to, followed by the write-intent bitmap (see bitmap.go).
+// Stripe chunks start at dataAreaOffset.
+//
+// Layout (little endian):
+//
+//	[magic 4][version 4][uuid 16][data 4][parity 4][chunk 4]
+//	[polynomial 4][matrix 4][index 4][events 8][state 4]
+//	[degraded events 8][region stripes 4]
+//	[reshape from data 4][reshape from parity 4][reshape position 8]
+//	[reshape stripes 8][reshape size 8][layout 4] ... [dirty map at 128] ... [crc 4]
+//
+// The checksum in the last four bytes covers everything before it.
+// The dirty map and degraded events are described in resync.go, the
+// reshape fields in reshape.go. While a reshape runs, the geometry fields
+// describe the new geometry.
+
+const (
+	superblockMagic   = 0x42364452 // "RD6B"
+	superblockVersion = 2
+	superblockSize    = 4096
+
+	dataAreaOffset = superblockSize + bitmapSize
+
+	dirtyMapOffset = 128
+	dirtyMapBytes  = superblockSize - 4 - dirtyMapOffset
+
+	// matrixVandermonde identifies the encoding matrix from fixedVandermond.
+	matrixVandermonde = 1
+
+	// StateClean means the array was stopped with all stripes consistent.
+	StateClean = 1
+	// StateActive means the array is assembled and may have writes in flight.
+	StateActive = 2
+)
+
+// errNoSuperblock is returned if a disk does not carry a valid superblock.
+var errNoSuperblock = errors.New("no valid superblock")
+
+type superblock struct {
+	uuid         [16]byte
+	dataShards   int
+	parityShards int
+	chunkSize    int
+	polynomial   int
+	matrixType   int
+	index        int
+	events       uint64
+	state        int
+
+	degradedEvents uint64
+	regionStripes  int
+	dirty          []byte
+
+	reshapeFromData   int
+	reshapeFromParity int
+	reshapePosition   int64
+	reshapeStripes    int64
+	reshapeSize       int64
+
+	layout Layout
+}
+
+func (sb *superblock) marshal() []byte {
+	buf := make([]byte, superblockSize)
+	binary.LittleEndian.PutUint32(buf[0:4], superblockMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], superblockVersion)
+	copy(buf[8:24], sb.uuid[:])
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(sb.dataShards))
+	binary.LittleEndian.PutUint32(buf[28:32], uint32(sb.parityShards))
+	binary.LittleEndian.PutUint32(buf[32:36], uint32(sb.chunkSize))
+	binary.LittleEndian.PutUint32(buf[36:40], uint32(sb.polynomial))
+	binary.LittleEndian.PutUint32(buf[40:44], uint32(sb.matrixType))
+	binary.LittleEndian.PutUint32(buf[44:48], uint32(sb.index))
+	binary.LittleEndian.PutUint64(buf[48:56], sb.events)
+	binary.LittleEndian.PutUint32(buf[56:60], uint32(sb.state))
+	binary.LittleEndian.PutUint64(buf[60:68], sb.degradedEvents)
+	binary.LittleEndian.PutUint32(buf[68:72], uint32(sb.regionStripes))
+	binary.LittleEndian.PutUint32(buf[72:76], uint32(sb.reshapeFromData))
+	binary.LittleEndian.PutUint32(buf[76:80], uint32(sb.reshapeFromParity))
+	binary.LittleEndian.PutUint64(buf[80:88], uint64(sb.reshapePosition))
+	binary.LittleEndian.PutUint64(buf[88:96], uint64(sb.reshapeStripes))
+	binary.LittleEndian.PutUint64(buf[96:104], uint64(sb.reshapeSize))
+	binary.LittleEndian.PutUint32(buf[104:108], uint32(sb.layout))
+	copy(buf[dirtyMapOffset:superblockSize-4], sb.dirty)
+	crc := crc32.ChecksumIEEE(buf[:superblockSize-4])
+	binary.LittleEndian.PutUint32(buf[superblockSize-4:], crc)
+	return buf
+}
+
+func unmarshalSuperblock(buf []byte) (*superblock, error) {
+	if len(buf) != superblockSize || binary.LittleEndian.Uint32(buf[0:4]) != superblockMagic {
+		return nil, errNoSuperblock
+	}
+	crc := crc32.ChecksumIEEE(buf[:superblockSize-4])
+	if crc != binary.LittleEndian.Uint32(buf[superblockSize-4:]) {
+		return nil, errNoSuperblock
+	}
+	if binary.LittleEndian.Uint32(buf[4:8]) != superblockVersion {
+		return nil, errors.New("unsupported superblock version")
+	}
+
+	sb := superblock{
+		dataShards:   int(binary.LittleEndian.Uint32(buf[24:28])),
+		parityShards: int(binary.LittleEndian.Uint32(buf[28:32])),
+		chunkSize:    int(binary.LittleEndian.Uint32(buf[32:36])),
+		polynomial:   int(binary.LittleEndian.Uint32(buf[36:40])),
+		matrixType:   int(binary.LittleEndian.Uint32(buf[40:44])),
+		index:        int(binary.LittleEndian.Uint32(buf[44:48])),
+		events:       binary.LittleEndian.Uint64(buf[48:56]),
+		state:        int(binary.LittleEndian.Uint32(buf[56:60])),
+
+		degradedEvents: binary.LittleEndian.Uint64(buf[60:68]),
+		regionStripes:  int(binary.LittleEndian.Uint32(buf[68:72])),
+		dirty:          make([]byte, dirtyMapBytes),
+
+		reshapeFromData:   int(binary.LittleEndian.Uint32(buf[72:76])),
+		reshapeFromParity: int(binary.LittleEndian.Uint32(buf[76:80])),
+		reshapePosition:   int64(binary.LittleEndian.Uint64(buf[80:88])),
+		reshapeStripes:    int64(binary.LittleEndian.Uint64(buf[88:96])),
+		reshapeSize:       int64(binary.LittleEndian.Uint64(buf[96:104])),
+
+		layout: Layout(binary.LittleEndian.Uint32(buf[104:108])),
+	}
+	copy(sb.uuid[:], buf[8:24])
+	copy(sb.dirty, buf[dirtyMapOffset:superblockSize-4])
+	return &sb, nil
+}
+
+func readSuperblock(d Disk) (*superblock, error) {
+	buf := make([]byte, superblockSize)
+	err := readFull(d, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalSuperblock(buf)
+}
+
+// sameGeometry reports whether two superblocks describe the same array layout.
+func (sb *superblock) sameGeometry(other *superblock) bool {
+	return sb.uuid == other.uuid &&
+		sb.dataShards == other.dataShards &&
+		sb.parityShards == other.parityShards &&
+		sb.chunkSize == other.chunkSize &&
+		sb.polynomial == other.polynomial &&
+		sb.matrixType == other.matrixType &&
+		sb.layout == other.layout &&
+		sb.reshapeFromData == other.reshapeFromData &&
+		sb.reshapeFromParity == other.reshapeFromParity
+}
+
+// memberCount returns the number of members of both geometries.
+func (sb *superblock) memberCount() int {
+	count := sb.dataShards + sb.parityShards
+	if sb.reshapeFromData+sb.reshapeFromParity > count {
+		count = sb.reshapeFromData + sb.reshapeFromParity
+	}
+	return count
+}
+
+// superblockFor returns the superblock of member i in the current state.
+func (v *Volume) superblockFor(i int) *superblock {
+	sb := superblock{
+		uuid:         v.uuid,
+		dataShards:   v.r.dataShards,
+		parityShards: v.r.parityShards,
+		chunkSize:    v.chunkSize,
+		polynomial:   generatingPolynomial,
+		matrixType:   matrixVandermonde,
+		index:        i,
+		events:       v.events,
+		state:        v.state,
+
+		degradedEvents: v.degradedEvents,
+		regionStripes:  v.regionStripes,
+		dirty:          v.dirty,
+
+		layout: v.layout,
+	}
+	if rs := v.reshape; rs != nil {
+		sb.reshapeFromData = rs.old.r.dataShards
+		sb.reshapeFromParity = rs.old.r.parityShards
+		sb.reshapePosition = rs.position
+		sb.reshapeStripes = rs.stripes
+		sb.reshapeSize = rs.size
+	}
+	return &sb
+}
+
+// members returns every member disk, including members of the old
+// geometry that leave the array when a reshape finishes.
+func (v *Volume) members() []Disk {
+	if v.reshape != nil && len(v.reshape.old.disks) > len(v.disks) {
+		return v.reshape.old.disks
+	}
+	return v.disks
+}
+
+// setState bumps the event counter and records the new state on every
+// present member. Members that miss the update become stale.
+func (v *Volume) setState(state int) error {
+	v.events++
+	v.state = state
+	return v.writeSuperblocks()
+}
+
+// writeSuperblocks stores the current superblock on every present member.
+func (v *Volume) writeSuperblocks() error {
+	if !v.hasSuperblock {
+		return nil
+	}
+	for i, d := range v.members() {
+		if d == nil {
+			continue
+		}
+		_, err := d.WriteAt(v.superblockFor(i).marshal(), 0)
+		if err != nil {
+			return fmt.Errorf("disk %d: %w", i, err)
+		}
+		err = d.Sync()
+		if err != nil {
+			return fmt.Errorf("disk %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// CreateVolume initializes the files at paths as members of a new array
+// and returns it assembled. Member i is paths[i].
+func CreateVolume(dataShards, parityShards, chunkSize int, paths ...string) (*Volume, error) {
+	disks := make([]Disk, len(paths))
+	for i, path := range paths {
+		d, err := OpenFileDisk(path)
+		if err != nil {
+			closeDisks(disks)
+			return nil, err
+		}
+		disks[i] = d
+	}
+	return createVolume(dataShards, parityShards, chunkSize, disks)
+}
+
+// createVolume is CreateVolume on disks that are already open. It takes
+// ownership of the disks.
+func createVolume(dataShards, parityShards, chunkSize int, disks []Disk) (*Volume, error) {
+	v, err := NewVolume(dataShards, parityShards, chunkSize, disks)
+	if err != nil {
+		closeDisks(disks)
+		return nil, err
+	}
+	_, err = rand.Read(v.uuid[:])
+	if err != nil {
+		closeDisks(disks)
+		return nil, err
+	}
+	v.dataOffset = dataAreaOffset
+	v.hasSuperblock = true
+	err = v.setState(StateActive)
+	if err != nil {
+		closeDisks(disks)
+		return nil, err
+	}
+	return v, nil
+}
+
+// Assemble opens the files at paths, reads their superblocks and builds
+// the array they belong to. Paths may be given in any order; members are
+// placed by the index in their superblock. Disks without a superblock or
+// belonging to another array are rejected. Stale members, whose event
+// counter is behind the rest, are resynced where they diverged or
+// treated as failed (see readmit). The array starts degraded when
+// members are missing, as long as at least dataShards remain.
+func Assemble(paths ...string) (*Volume, error) {
+	opened, err := openDisks(paths, OpenFileDisk)
+	if err != nil {
+		return nil, err
+	}
+	return assembleDisks(paths, opened, false)
+}
+
+// errReadOnly is returned by calls that would write to an array
+// assembled with AssembleReadOnly.
+var errReadOnly = errors.New("array is assembled read-only")
+
+// AssembleReadOnly builds the array like Assemble without writing to any
+// member, for inspecting it. The files are opened read-only, the event
+// counter and state are left alone and stale members are left out
+// instead of being resynced. Writes, rebuilds, repairs and reshape steps
+// fail with an error, and Close only closes the files. After an unclean
+// shutdown the parity of the stripes that had writes in flight is not
+// re-verified.
+func AssembleReadOnly(paths ...string) (*Volume, error) {
+	opened, err := openDisks(paths, func(path string) (Disk, error) {
+		return os.Open(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assembleDisks(paths, opened, true)
+}
+
+func openDisks(paths []string, open func(string) (Disk, error)) ([]Disk, error) {
+	opened := make([]Disk, 0, len(paths))
+	for _, path := range paths {
+		d, err := open(path)
+		if err != nil {
+			closeDisks(opened)
+			return nil, err
+		}
+		opened = append(opened, d)
+	}
+	return opened, nil
+}
+
+// assembleDisks is Assemble on disks that are already open, named by
+// paths in messages. It takes ownership of the disks.
+func assembleDisks(paths []string, opened []Disk, readOnly bool) (*Volume, error) {
+	var disks []Disk
+	var names []string
+	var supers []*superblock
+	for i, d := range opened {
+		path := paths[i]
+		sb, err := readSuperblock(d)
+		if err != nil {
+			fmt.Printf("Rejecting %s: %s \n", path, err)
+			d.Close()
+			continue
+		}
+		disks = append(disks, d)
+		names = append(names, path)
+		supers = append(supers, sb)
+	}
+	if len(supers) == 0 {
+		return nil, errors.New("no members with a valid superblock")
+	}
+
+	// The array with the most members wins, the rest are foreign.
+	// Within it, the freshest superblock describes the current state.
+	count := make(map[[16]byte]int)
+	for _, sb := range supers {
+		count[sb.uuid]++
+	}
+	newest := 0
+	for i, sb := range supers {
+		best := supers[newest]
+		if count[sb.uuid] > count[best.uuid] || (sb.uuid == best.uuid && sb.events > best.events) {
+			newest = i
+		}
+	}
+	ref := supers[newest]
+	if ref.polynomial != generatingPolynomial || ref.matrixType != matrixVandermonde {
+		closeDisks(disks)
+		return nil, errors.New("unsupported field or matrix type")
+	}
+	if ref.layout < 0 || int(ref.layout) >= len(layoutNames) {
+		closeDisks(disks)
+		return nil, fmt.Errorf("unsupported %s", ref.layout)
+	}
+
+	members := make([]Disk, ref.memberCount())
+	var stale []int
+	for i, sb := range supers {
+		switch {
+		case !sb.sameGeometry(ref):
+			fmt.Printf("Rejecting foreign member %s \n", names[i])
+		case sb.index < 0 || sb.index >= len(members):
+			fmt.Printf("Rejecting %s: invalid index %d \n", names[i], sb.index)
+		case sb.events < ref.events:
+			stale = append(stale, i)
+			continue
+		case members[sb.index] != nil:
+			fmt.Printf("Rejecting %s: duplicate member %d \n", names[i], sb.index)
+		default:
+			members[sb.index] = disks[i]
+			continue
+		}
+		disks[i].Close()
+	}
+
+	v, err := NewVolume(ref.dataShards, ref.parityShards, ref.chunkSize, members[:ref.dataShards+ref.parityShards])
+	if err != nil {
+		closeDisks(members)
+		closeStale(disks, stale)
+		return nil, err
+	}
+	v.uuid = ref.uuid
+	v.events = ref.events
+	v.dataOffset = dataAreaOffset
+	v.hasSuperblock = true
+	v.degradedEvents = ref.degradedEvents
+	if ref.regionStripes > 0 {
+		v.regionStripes = ref.regionStripes
+	}
+	copy(v.dirty, ref.dirty)
+	v.layout = ref.layout
+	if ref.reshapeFromData > 0 {
+		err = v.resumeReshape(ref, members)
+		if err != nil {
+			closeDisks(members)
+			closeStale(disks, stale)
+			return nil, err
+		}
+	}
+
+	if len(v.missing()) > v.r.parityShards {
+		closeDisks(members)
+		closeStale(disks, stale)
+		return nil, fmt.Errorf("only %d of %d members present", v.r.totalShards-len(v.missing()), v.r.totalShards)
+	}
+	v.noteDegraded()
+	if readOnly {
+		v.readOnly = true
+		closeStale(disks, stale)
+		if ref.state == StateActive {
+			fmt.Printf("Unclean shutdown: parity not re-verified in read-only mode \n")
+		}
+		return v, nil
+	}
+	err = v.setState(StateActive)
+	if err != nil {
+		closeDisks(members)
+		closeStale(disks, stale)
+		return nil, err
+	}
+
+	// After an unclean shutdown, re-verify the regions that had writes
+	// in flight before stale members are resynced from them.
+	if ref.state == StateActive {
+		v.loadBitmap()
+		repaired, err := v.resyncIntent()
+		if err != nil {
+			closeDisks(members)
+			closeStale(disks, stale)
+			return nil, err
+		}
+		fmt.Printf("Unclean shutdown: repaired parity of %d stripes \n", repaired)
+	}
+
+	for _, i := range stale {
+		err = v.readmit(supers[i], disks[i])
+		if err != nil {
+			fmt.Printf("Rejecting stale member %s: %s \n", names[i], err)
+			disks[i].Close()
+		}
+	}
+	return v, nil
+}
+
+func closeStale(disks []Disk, stale []int) {
+	for _, i := range stale {
+		disks[i].Close()
+	}
+}
+
+func closeDisks(disks []Disk) {
+	for _, d := range disks {
+		if d != nil {
+			d.Close()
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package raid6
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// shardPaths returns n member file names in dir.
+func shardPaths(dir string, n int) []string {
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, "shard-"+string(rune('0'+i)))
+	}
+	return paths
+}
+
+// createArray creates a 4+2 array in dir, writes data to it and stops it
+// cleanly.
+func createArray(t *testing.T, dir string, data []byte) []string {
+	t.Helper()
+	paths := shardPaths(dir, 6)
+	v, err := CreateVolume(4, 2, 64, paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+// checkRead fails unless v holds want at offset 0.
+func checkRead(t *testing.T, v *Volume, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := v.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("assembled array returned wrong data")
+	}
+}
+
+// superblockOf reads the superblock of the member file at path.
+func superblockOf(t *testing.T, path string) *superblock {
+	t.Helper()
+	d, err := OpenFileDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	sb, err := readSuperblock(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sb
+}
+
+// TestAssembleOrder checks that members are placed by the index in their
+// superblock, whatever order they are given in.
+func TestAssembleOrder(t *testing.T) {
+	data := bytes.Repeat([]byte("order"), 500)
+	paths := createArray(t, t.TempDir(), data)
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 5; trial++ {
+		shuffled := append([]string(nil), paths...)
+		rng.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		v, err := Assemble(shuffled...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Degraded() {
+			t.Fatalf("shuffled assemble is degraded: missing %v", v.Missing())
+		}
+		checkRead(t, v, data)
+		if err := v.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestAssembleForeign checks that a member of another array is rejected,
+// both next to a complete array and in place of one of its members.
+func TestAssembleForeign(t *testing.T) {
+	data := bytes.Repeat([]byte("ours"), 500)
+	paths := createArray(t, t.TempDir(), data)
+	other := createArray(t, t.TempDir(), bytes.Repeat([]byte("theirs"), 500))
+
+	v, err := Assemble(append(paths, other[0])...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Degraded() {
+		t.Fatalf("foreign member displaced a member: missing %v", v.Missing())
+	}
+	checkRead(t, v, data)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mixed := append([]string(nil), paths...)
+	mixed[2] = other[2]
+	v, err = Assemble(mixed...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := v.Missing(); !reflect.DeepEqual(missing, []int{2}) {
+		t.Fatalf("missing %v, want [2]", missing)
+	}
+	checkRead(t, v, data)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sb := superblockOf(t, other[2]); sb.uuid == superblockOf(t, paths[0]).uuid {
+		t.Fatal("foreign member was adopted")
+	}
+}
+
+// TestAssembleStale checks that a member with an older event count, which
+// left before dirty tracking could record what it missed, is rejected.
+func TestAssembleStale(t *testing.T) {
+	paths := createArray(t, t.TempDir(), bytes.Repeat([]byte("old"), 500))
+	old, err := os.ReadFile(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("new"), 500)
+	if _, err := v.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths[1], old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := v.Missing(); !reflect.DeepEqual(missing, []int{1}) {
+		t.Fatalf("missing %v, want stale member 1", missing)
+	}
+	checkRead(t, v, data)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(paths[1])
+	if !bytes.Equal(after, old) {
+		t.Fatal("rejected stale member was written to")
+	}
+}
+
+// TestAssembleDegraded checks that an array starts with a member missing,
+// serves reads and writes, and records when it became degraded.
+func TestAssembleDegraded(t *testing.T) {
+	data := bytes.Repeat([]byte("degraded"), 300)
+	paths := createArray(t, t.TempDir(), data)
+	present := append(paths[:4:4], paths[5])
+
+	v, err := Assemble(present...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Degraded() || !reflect.DeepEqual(v.Missing(), []int{4}) {
+		t.Fatalf("missing %v, want [4]", v.Missing())
+	}
+	checkRead(t, v, data)
+	data = bytes.Repeat([]byte("still up"), 300)
+	if _, err := v.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, v, data)
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sb := superblockOf(t, paths[0]); sb.degradedEvents == 0 {
+		t.Fatal("degraded array did not record when it became degraded")
+	}
+
+	if _, err := Assemble(paths[:3]...); err == nil {
+		t.Fatal("assembled an array with three of six members")
+	}
+}
+
+// TestCloseMarksClean checks that an assembled array is active until it is
+// closed, and clean afterwards, with the event counter moving forward.
+func TestCloseMarksClean(t *testing.T) {
+	paths := createArray(t, t.TempDir(), []byte("clean"))
+	before := superblockOf(t, paths[0])
+	if before.state != StateClean {
+		t.Fatalf("created array left in state %d", before.state)
+	}
+
+	v, err := Assemble(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if sb := superblockOf(t, path); sb.state != StateActive {
+			t.Fatalf("%s in state %d while assembled", path, sb.state)
+		}
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		sb := superblockOf(t, path)
+		if sb.state != StateClean {
+			t.Fatalf("%s in state %d after Close", path, sb.state)
+		}
+		if sb.events <= before.events {
+			t.Fatalf("%s events %d, not after %d", path, sb.events, before.events)
+		}
+	}
+}
+
+// TestAssembleReadOnly checks that inspecting an array, degraded and
+// after an unclean shutdown, leaves every member file untouched.
+func TestAssembleReadOnly(t *testing.T) {
+	paths := shardPaths(t.TempDir(), 6)
+	v, err := CreateVolume(4, 2, 64, paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("read-only"), 300)
+	if _, err := v.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	// Leave the array active, as after a crash, with one member gone.
+	for _, d := range v.disks {
+		d.Close()
+	}
+	if err := os.Remove(paths[2]); err != nil {
+		t.Fatal(err)
+	}
+	present := append(paths[:2:2], paths[3:]...)
+	before := make([][]byte, len(present))
+	for i, path := range present {
+		before[i], _ = os.ReadFile(path)
+	}
+
+	v, err = AssembleReadOnly(present...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(data))
+	if _, err := v.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read-only array returned wrong data")
+	}
+	if _, err := v.WriteAt(data, 0); err != errReadOnly {
+		t.Fatalf("write to read-only array: %v", err)
+	}
+	if _, err := v.ScrubStripe(0, true); err != errReadOnly {
+		t.Fatalf("repair of read-only array: %v", err)
+	}
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range present {
+		after, _ := os.ReadFile(path)
+		if !bytes.Equal(before[i], after) {
+			t.Fatalf("%s changed by a read-only assemble", path)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package raid6
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Volume stores stripes on a set of member disks.
+// Each stripe holds one chunk per member at offset dataOffset + s*chunkSize;
+// the layout decides which member holds which shard. A nil member is
+// missing and is reconstructed on read.
+//
+// A Volume may be used from several goroutines. Stripe I/O, member
+// changes and reshape steps are serialized by mu, so reads and writes
+// can be issued while another goroutine drives a reshape.
+type Volume struct {
+	mu sync.Mutex
+
+	r          *raid6
+	disks      []Disk
+	chunkSize  int
+	dataOffset int64
+	journal    *Journal
+
+	// Superblock state of file-backed arrays, see superblock.go.
+	hasSuperblock bool
+	readOnly      bool
+	uuid          [16]byte
+	events        uint64
+	state         int
+
+	// Divergence tracking while members are missing, see resync.go.
+	degradedEvents uint64
+	regionStripes  int
+	dirty          []byte
+
+	// Write-intent bitmap, see bitmap.go.
+	intent       []byte
+	intentWrites int
+
+	// Geometry change in progress, see reshape.go.
+	reshape *reshapeState
+	// Placement of shards on members, see layout.go.
+	layout Layout
+}
+
+// errMissingMember is returned when reading from a member that is not present.
+var errMissingMember = errors.New("member disk is missing")
+
+// NewVolume creates a volume over the given disks.
+// len(disks) must equal dataShards + parityShards.
+func NewVolume(dataShards, parityShards, chunkSize int, disks []Disk) (*Volume, error) {
+	r, err := newRaid6(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 {
+		return nil, errors.New("invalid chunk size")
+	}
+	if len(disks) != r.totalShards {
+		return nil, fmt.Errorf("expected %d disks, got %d", r.totalShards, len(disks))
+	}
+
+	v := Volume{
+		r:             r,
+		disks:         disks,
+		chunkSize:     chunkSize,
+		regionStripes: defaultRegionStripes,
+		dirty:         make([]byte, dirtyMapBytes),
+		intent:        make([]byte, bitmapBytes),
+	}
+	return &v, nil
+}
+
+// StripeSize returns the number of data bytes held by one stripe.
+func (v *Volume) StripeSize() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.stripeSize()
+}
+
+func (v *Volume) stripeSize() int {
+	return v.r.dataShards * v.chunkSize
+}
+
+// UseJournal replays pending records from j onto the member disks and
+// then routes every later stripe write through j.
+func (v *Volume) UseJournal(j *Journal) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return errReadOnly
+	}
+	_, err := j.replay(v.applyRecord)
+	if err != nil {
+		return err
+	}
+	v.journal = j
+	return nil
+}
+
+// applyRecord writes a journaled stripe back to the members.
+// A record for the stripe a reshape was rewriting completes that step.
+func (v *Volume) applyRecord(stripe int64, payload []byte) error {
+	if stripe&journalOldGeometry != 0 {
+		// A write in the old geometry was pending, so the reshape has
+		// not moved past its position since.
+		if v.reshape == nil {
+			return fmt.Errorf("journal record for old geometry stripe %d without a reshape", stripe&^journalOldGeometry)
+		}
+		return v.reshape.old.applyRecord(stripe&^journalOldGeometry, payload)
+	}
+	if len(payload) != v.r.totalShards*v.chunkSize {
+		return fmt.Errorf("journal record for stripe %d does not match volume geometry", stripe)
+	}
+	shards := make([][]byte, v.r.totalShards)
+	for i := range shards {
+		shards[i] = payload[i*v.chunkSize : (i+1)*v.chunkSize]
+	}
+	err := v.writeChunks(stripe, shards)
+	if err != nil {
+		return err
+	}
+	if v.reshape != nil && stripe == v.reshape.nextStripe() {
+		return v.advanceReshape()
+	}
+	return nil
+}
+
+func (v *Volume) chunkOffset(stripe int64) int64 {
+	return v.dataOffset + stripe*int64(v.chunkSize)
+}
+
+// encodeStripe splits data into chunks, padding it with zeros, and
+// returns the chunks of every member.
+func (v *Volume) encodeStripe(data []byte) (Matrix, error) {
+	buf := make([]byte, v.stripeSize())
+	copy(buf, data)
+	shards := make([][]byte, v.r.dataShards)
+	for i := range shards {
+		shards[i] = buf[i*v.chunkSize : (i+1)*v.chunkSize]
+	}
+	return v.r.encodingMatrix.Multiply(shards)
+}
+
+// WriteStripe encodes data and writes the full stripe to the members.
+// Data shorter than StripeSize is padded with zeros.
+// During a reshape, only stripes already in the new geometry can be
+// written this way; use WriteAt instead.
+func (v *Volume) WriteStripe(stripe int64, data []byte) error {
+	if stripe < 0 {
+		return errors.New("invalid stripe number")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return errReadOnly
+	}
+	// A reshape changes the stripe size, so check it under the lock.
+	if len(data) > v.stripeSize() {
+		return fmt.Errorf("data (%d bytes) exceeds stripe size (%d bytes)", len(data), v.stripeSize())
+	}
+	if v.layoutFor(stripe) != v {
+		return errReshaping
+	}
+	return v.writeStripe(stripe, data)
+}
+
+func (v *Volume) writeStripe(stripe int64, data []byte) error {
+	encoded, err := v.encodeStripe(data)
+	if err != nil {
+		return err
+	}
+
+	// Record the divergence before any member can see the new data.
+	err = v.markDirty(stripe)
+	if err != nil {
+		return err
+	}
+	err = v.markIntent(stripe)
+	if err != nil {
+		return err
+	}
+
+	if v.journal == nil {
+		return v.writeChunks(stripe, encoded)
+	}
+
+	// Journal first, so a crash halfway through the member writes
+	// can be repaired by replaying the whole stripe.
+	offset, err := v.journal.append(stripe, encoded)
+	if err != nil {
+		return err
+	}
+	err = v.writeChunks(stripe, encoded)
+	if err != nil {
+		return err
+	}
+	return v.journal.complete(offset)
+}
+
+// writeChunks writes each shard to its present member, as placed by the
+// layout, and syncs them. A member that fails the write is failed out of
+// the array, and the write succeeds as long as the array can still be read.
+func (v *Volume) writeChunks(stripe int64, shards [][]byte) error {
+	offset := v.chunkOffset(stripe)
+	for shard, i := range v.layout.Map(stripe, v.r.dataShards, v.r.parityShards) {
+		d := v.disks[i]
+		if d == nil {
+			// Degraded write: the chunk is rebuilt when the member returns.
+			continue
+		}
+		_, err := d.WriteAt(shards[shard], offset)
+		if err == nil {
+			err = d.Sync()
+		}
+		if err != nil {
+			failErr := v.failMember(i)
+			if failErr != nil {
+				return fmt.Errorf("disk %d: %w", i, err)
+			}
+			failErr = v.markDirty(stripe)
+			if failErr != nil {
+				return failErr
+			}
+		}
+	}
+	return nil
+}
+
+// codec returns a copy of r working on shards, so that decoding a stripe
+// does not touch the DiskArray shared by the volume.
+func (r *raid6) codec(shards [][]byte) *raid6 {
+	c := *r
+	c.DiskArray = shards
+	return &c
+}
+
+// readChunks reads every shard of a stripe in encoding order. Shards of
+// missing or failing members are returned as nil.
+func (v *Volume) readChunks(stripe int64) [][]byte {
+	offset := v.chunkOffset(stripe)
+	shards := make([][]byte, v.r.totalShards)
+	for shard, d := range v.stripeDisks(stripe) {
+		if d == nil {
+			continue
+		}
+		chunk := make([]byte, v.chunkSize)
+		if readFull(d, chunk, offset) == nil {
+			shards[shard] = chunk
+		}
+	}
+	return shards
+}
+
+// ReadStripe returns the data of a stripe, reconstructing chunks of
+// missing members from parity. Like WriteStripe, it is limited to the
+// new geometry during a reshape.
+func (v *Volume) ReadStripe(stripe int64) ([]byte, error) {
+	if stripe < 0 {
+		return nil, errors.New("invalid stripe number")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.layoutFor(stripe) != v {
+		return nil, errReshaping
+	}
+	return v.readStripe(stripe)
+}
+
+func (v *Volume) readStripe(stripe int64) ([]byte, error) {
+	r := v.r.codec(v.readChunks(stripe))
+	err := r.ReconstructDisk()
+	if err != nil {
+		return nil, fmt.Errorf("stripe %d: %w", stripe, err)
+	}
+
+	data := make([]byte, 0, v.stripeSize())
+	for _, chunk := range r.DiskArray[:r.dataShards] {
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// VerifyStripe checks the parity chunks of a stripe against its data.
+// It requires every data member to be readable.
+func (v *Volume) VerifyStripe(stripe int64) ([]bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	shards := v.readChunks(stripe)
+	for i := 0; i < v.r.dataShards; i++ {
+		if shards[i] == nil {
+			return nil, fmt.Errorf("stripe %d: %w", stripe, errMissingMember)
+		}
+	}
+	valid, _ := v.r.codec(shards).Verify()
+	return valid, nil
+}
+
+// Size returns the logical size of the array in bytes.
+func (v *Volume) Size() (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.size()
+}
+
+func (v *Volume) size() (int64, error) {
+	if v.reshape != nil {
+		return v.reshape.size, nil
+	}
+	count, err := v.stripeCount()
+	return count * int64(v.stripeSize()), err
+}
+
+// locate returns the geometry holding logical offset off, the stripe in
+// that geometry and the offset within the stripe.
+func (v *Volume) locate(off int64) (*Volume, int64, int) {
+	stripeSize := int64(v.stripeSize())
+	g := v.layoutFor(off / stripeSize)
+	if g != v {
+		stripeSize = int64(g.stripeSize())
+	}
+	return g, off / stripeSize, int(off % stripeSize)
+}
+
+// ReadAt reads len(p) logical bytes at off. It is safe to use while a
+// reshape is in progress.
+func (v *Volume) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		g, stripe, within := v.locate(off + int64(n))
+		data, err := g.readRange(stripe, within, min(len(p)-n, g.stripeSize()-within))
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data)
+	}
+	return n, nil
+}
+
+// readRange returns n data bytes of a stripe from offset within. Every
+// chunk touched is read directly if its member is readable; otherwise
+// only the same columns of other members are read and decoded.
+func (v *Volume) readRange(stripe int64, within, n int) ([]byte, error) {
+	data := make([]byte, 0, n)
+	disks := v.stripeDisks(stripe)
+	offset := v.chunkOffset(stripe)
+	for n > 0 {
+		shard := within / v.chunkSize
+		col := within % v.chunkSize
+		length := min(n, v.chunkSize-col)
+
+		chunk := make([]byte, length)
+		if disks[shard] == nil || readFull(disks[shard], chunk, offset+int64(col)) != nil {
+			var err error
+			chunk, err = v.decodeRange(disks, offset, shard, col, length)
+			if err != nil {
+				return nil, fmt.Errorf("stripe %d: %w", stripe, err)
+			}
+		}
+		data = append(data, chunk...)
+		within += length
+		n -= length
+	}
+	return data, nil
+}
+
+// decodeRange rebuilds columns [col, col+length) of a data shard from
+// the same columns of the first readable members.
+func (v *Volume) decodeRange(disks []Disk, offset int64, shard, col, length int) ([]byte, error) {
+	windows := make([][]byte, v.r.totalShards)
+	read := 0
+	for i, d := range disks {
+		if i == shard || d == nil || read == v.r.dataShards {
+			continue
+		}
+		window := make([]byte, length)
+		if readFull(d, window, offset+int64(col)) == nil {
+			windows[i] = window
+			read++
+		}
+	}
+	data, err := v.r.ReconstructRange(windows, 0, length)
+	if err != nil {
+		return nil, err
+	}
+	return data[shard], nil
+}
+
+// WriteAt writes p at logical offset off, updating partially covered
+// stripes by read-modify-write. It is safe to use while a reshape is in
+// progress.
+func (v *Volume) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.readOnly {
+		return 0, errReadOnly
+	}
+	n := 0
+	for n < len(p) {
+		g, stripe, within := v.locate(off + int64(n))
+		data, err := g.readStripe(stripe)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(data[within:], p[n:])
+		if g != v {
+			err = v.writeOldStripe(stripe, data)
+		} else {
+			err = v.writeStripe(stripe, data)
+		}
+		if err != nil {
+			return n, err
+		}
+		n += copied
+	}
+	return n, nil
+}
+
+// VolumeInfo describes the geometry and health of a volume.
+type VolumeInfo struct {
+	UUID         string
+	DataShards   int
+	ParityShards int
+	ChunkSize    int
+	Layout       Layout
+	Missing      []int
+	Events       uint64
+	State        int
+	Stripes      int64
+	Size         int64
+
+	Reshaping    bool
+	ReshapeDone  int64
+	ReshapeTotal int64
+}
+
+// Info returns the geometry and health of the volume.
+func (v *Volume) Info() (VolumeInfo, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	info := VolumeInfo{
+		UUID:         fmt.Sprintf("%x-%x-%x-%x-%x", v.uuid[0:4], v.uuid[4:6], v.uuid[6:8], v.uuid[8:10], v.uuid[10:16]),
+		DataShards:   v.r.dataShards,
+		ParityShards: v.r.parityShards,
+		ChunkSize:    v.chunkSize,
+		Layout:       v.layout,
+		Missing:      v.missing(),
+		Events:       v.events,
+		State:        v.state,
+	}
+	info.ReshapeDone, info.ReshapeTotal, info.Reshaping = v.reshapeProgress()
+
+	var err error
+	info.Stripes, err = v.stripeCount()
+	if err != nil {
+		return info, err
+	}
+	info.Size, err = v.size()
+	return info, err
+}
+
+// Missing returns the indices of members that are not present.
+func (v *Volume) Missing() []int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.missing()
+}
+
+func (v *Volume) missing() []int {
+	var missing []int
+	for i, d := range v.disks {
+		if d == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Degraded reports whether any member is missing.
+func (v *Volume) Degraded() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.degraded()
+}
+
+func (v *Volume) degraded() bool {
+	return len(v.missing()) > 0
+}
+
+// Close clears the write-intent bitmap and marks the array clean, then
+// closes the journal and every present member.
+func (v *Volume) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var firstErr error
+	if v.hasSuperblock && !v.readOnly {
+		for i := range v.intent {
+			v.intent[i] = 0
+		}
+		firstErr = v.writeBitmap()
+		err := v.setState(StateClean)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if v.journal != nil {
+		err := v.journal.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, d := range v.disks {
+		if d == nil {
+			continue
+		}
+		err := d.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}